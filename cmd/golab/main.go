@@ -2,16 +2,24 @@ package main
 
 import (
 	"Go-lab/config"
+	"Go-lab/internal/idempotency"
 	myMiddleware "Go-lab/internal/middleware"
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/middleware/ratelimit"
+	"Go-lab/internal/outbox"
 	"Go-lab/internal/player"
 	"Go-lab/internal/security"
 	"Go-lab/internal/utils"
 	"Go-lab/internal/utils/dbutils"
 	"Go-lab/internal/utils/httpconst"
+	"Go-lab/internal/utils/problem"
 	"Go-lab/internal/utils/session"
 	"Go-lab/internal/utils/session/session_db"
+	"Go-lab/internal/utils/tracecontext"
+	"Go-lab/internal/webhook"
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"log"
 	"log/slog"
@@ -36,7 +44,7 @@ var (
 )
 
 func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	logger := slog.New(tracecontext.NewLogHandler(slog.NewJSONHandler(os.Stdout, nil)))
 	slog.SetDefault(logger)
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
@@ -108,9 +116,35 @@ func initialise(ctx context.Context) (*http.Server, error) {
 		panic(err)
 	}
 
-	oauthConfig := security.NewOAuthConfig(ctx, cfg.App.BaseUrl)
+	oauthConfig := security.NewOAuthConfig(ctx, cfg.App.BaseUrl, cfg.Auth)
+
+	// signing keys from the database, rotated by the key rotation service
+	keyManager, err := security.NewKeyManager(dbUtils, cfg.Auth, cfg.App.IsDev())
+	if err != nil {
+		slog.Error("security.NewKeyManager", "error", err)
+		panic(err)
+	}
+	if err = keyManager.Refresh(session.ContextWithUserID(ctx, session.AdminUserID)); err != nil {
+		slog.Error("keyManager.Refresh", "error", err)
+		panic(err)
+	}
+	securityService := security.NewService(dbUtils, cfg.Auth, cfg.App.BaseUrl, keyManager)
+	// bearer tokens from the token endpoint, requests run as the token's subject
+	authenticate := securityService.Authenticate
+	// scopes of the token and roles of its subject
+	authorizer := security.NewAuthorizer(dbUtils)
+	canRead := authorizer.RequireScope(security.ScopeRead)
+	canWrite := authorizer.RequireScope(security.ScopeWrite)
+	isAdmin := authorizer.RequireScope(security.ScopeAdmin)
 
 	serviceRegistry = utils.NewServiceRegistry()
+	serviceRegistry.Register(security.NewRevocationPruneService(securityService, cfg.Auth.PruneEvery))
+	serviceRegistry.Register(security.NewKeyRotationService(keyManager))
+
+	// Idempotency-Key on the mutations, the response is kept in the transaction of the change
+	idempotencyKeeper := idempotency.NewKeeper(dbUtils, cfg.Idempotency)
+	idempotent := idempotencyKeeper.Handler
+	serviceRegistry.Register(idempotency.NewPruneService(idempotencyKeeper))
 	////////// plumbing //////////
 
 	////////// player //////////
@@ -120,8 +154,29 @@ func initialise(ctx context.Context) (*http.Server, error) {
 		panic(err)
 	}
 	playerService := player.NewService(dbUtils, playerApi)
+	serviceRegistry.Register(player.NewRetentionService(playerService, cfg.Retention))
+	if cfg.Reconcile.Enabled {
+		serviceRegistry.Register(player.NewReconcileService(playerService, cfg.Reconcile))
+	}
+	playerEvents := player.NewEventStream(playerService)
 	////////// player //////////
 
+	////////// webhook //////////
+	webhookService := webhook.NewService(dbUtils, cfg.Webhook)
+	serviceRegistry.Register(webhook.NewDeliveryService(webhookService, cfg.Webhook))
+	////////// webhook //////////
+
+	////////// outbox //////////
+	sinks, err := outbox.NewSinks(cfg.Outbox)
+	if err != nil {
+		slog.Error("outbox.NewSinks", "error", err)
+		panic(err)
+	}
+	// the webhooks are fed from the outbox, a crash between commit and publish loses nothing
+	sinks = append(sinks, webhook.NewSink(webhookService))
+	serviceRegistry.Register(outbox.NewRelayService(outbox.NewRelay(dbUtils, cfg.Outbox, sinks...)))
+	////////// outbox //////////
+
 	serviceRegistry.StartAll()
 
 	////////// router //////////
@@ -131,27 +186,41 @@ func initialise(ctx context.Context) (*http.Server, error) {
 	router.Use(middleware.RealIP)
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.RequestID)
+	router.Use(tracecontext.Middleware)
 	router.Use(middleware.StripSlashes)
 	router.Use(myMiddleware.SecureHandler)
 	// router.Use(myMiddleware.CacheHeaders)
-	router.Use(middleware.Throttle(int(cfg.App.Throttle)))
-	router.Use(middleware.Timeout(cfg.App.TimeoutInSeconds))
+	// not router.Use'd, streaming routes must outlive the service timeout
+	timeout := middleware.Timeout(cfg.App.TimeoutInSeconds)
+	// token buckets per client, API key or IP; used after authenticate so the client is known
+	rateStore := ratelimit.NewMemoryStore()
+	limiter := ratelimit.NewLimiter(cfg.RateLimit, rateStore, security.RateLimitKey)
+	// and per IP in front of authenticate, bad tokens and API key guesses cost a lookup each
+	ipLimit := ratelimit.NewLimiter(cfg.RateLimit, rateStore, ratelimit.ByIP).Group("ip", cfg.RateLimit.IP)
+	apiLimit := limiter.Group("api", cfg.RateLimit.API)
+	writeLimit := limiter.Group("write", cfg.RateLimit.Write)
+	tokenLimit := limiter.Group("token", cfg.RateLimit.Token)
+	// every route but the event stream, see below
+	served := []func(http.Handler) http.Handler{middleware.Throttle(int(cfg.App.Throttle))}
 	compression, err := httpcompression.DefaultAdapter()
 	if err == nil {
-		router.Use(compression)
+		served = append(served, compression)
 	} else {
 		slog.Warn("http compression not enabled", "error", err)
 	}
+	api := router.With(served...)
+
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		problem.WriteStatus(w, r, http.StatusMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path)
+	})
 
-	router.With(middleware.NoCache).Route(cfg.App.Root+"/session", func(r chi.Router) {
+	api.With(middleware.NoCache, timeout, ipLimit, authenticate, apiLimit).Route(cfg.App.Root+"/session", func(r chi.Router) {
 		r.Get("/currentUserId", func(w http.ResponseWriter, r *http.Request) {
-			ctx := session.ContextWithUserID(r.Context(), 1001)
+			ctx := r.Context()
 
 			err := dbUtils.WithTransaction(ctx, func(tx *sqlx.Tx) error {
 				if userId, err := session_db.GetUserIdFromDB(ctx, tx); err != nil {
-					slog.Error("session.GetUserIdFromDB", "error", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte("error"))
+					problem.Write(w, r, err)
 				} else {
 					w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
 					w.WriteHeader(http.StatusOK)
@@ -164,32 +233,96 @@ func initialise(ctx context.Context) (*http.Server, error) {
 			}
 		})
 	})
-	router.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+	if cfg.App.IsDev() {
+		// counters and circuit breaker states of the outbound clients, among others
+		api.With(timeout).Handle("/debug/vars", expvar.Handler())
+	}
+	api.With(timeout).Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 		requestID := middleware.GetReqID(r.Context())
 		pong := fmt.Sprintf("pong - request id: %s; IP=%s", requestID, r.RemoteAddr)
 		w.Write([]byte(pong))
 	})
 
-	playerHandler := player.NewHandler(playerService, cfg.App)
-	router.Route(cfg.App.Root+"/player", func(r chi.Router) {
-		r.Get("/", playerHandler.List)
-		r.Get("/{id}", playerHandler.Get)
-		r.Get("/resource/{resource_id}", playerHandler.GetResource)
-		r.Put("/checkin/{id}", playerHandler.Checkin)
-		r.Put("/{id}", playerHandler.Update)
-		r.Post("/", playerHandler.Create)
-		r.Delete("/{id}", playerHandler.Delete)
+	// event streams stay open, they would hold a throttle slot each and compression buffers them; the broker
+	// caps them instead
+	router.With(ipLimit, authenticate, apiLimit, canRead).Get(cfg.App.Root+"/player/events", playerEvents.ServeHTTP)
+
+	playerHandler := player.NewHandler(playerService, authorizer, cfg.App, cfg.Retention)
+	api.With(ipLimit, authenticate, apiLimit, canRead).Route(cfg.App.Root+"/player", func(r chi.Router) {
+		r.Get("/export", playerHandler.Export)
+		// its own deadline, see config.AppConfig.ImportTimeout; not idempotent, the import runs on a worker pool
+		// and cannot join the transaction
+		r.With(canWrite, writeLimit).Post("/import", playerHandler.Import)
+
+		r.Group(func(r chi.Router) {
+			r.Use(timeout)
+			r.Get("/", playerHandler.List)
+			r.With(etag.Conditional).Get("/trash", playerHandler.Trash)
+			r.With(isAdmin, writeLimit).Delete("/trash", playerHandler.PurgeTrash)
+			r.With(etag.Conditional).Get("/{id}/checkins", playerHandler.Checkins)
+			r.Get("/{id}", playerHandler.Get)
+			r.Get("/resource/{resource_id}", playerHandler.GetResource)
+			r.With(canWrite, writeLimit, idempotent).Post("/", playerHandler.Create)
+
+			// optimistic locking, the If-Match carries the version being changed
+			r.Group(func(r chi.Router) {
+				r.Use(canWrite, writeLimit, etag.RequireIfMatch, idempotent)
+				r.Post("/{id}/restore", playerHandler.Restore)
+				r.Put("/checkin/{id}", playerHandler.Checkin)
+				r.Put("/{id}", playerHandler.Update)
+				r.Patch("/{id}", playerHandler.Patch)
+				r.Delete("/{id}", playerHandler.Delete)
+			})
+		})
 	})
 
-	oauthHandler := security.NewHandler(ctx, cfg.App)
-	router.Route(cfg.App.Root+"/security", func(r chi.Router) {
-		r.Post("/oauth/token", oauthHandler.Auth)
+	webhookHandler := webhook.NewHandler(webhookService, cfg.App)
+	// the subscriptions hold the signing secrets
+	api.With(timeout, ipLimit, authenticate, apiLimit, isAdmin).Route(cfg.App.Root+"/webhook", func(r chi.Router) {
+		r.Get("/", webhookHandler.List)
+		r.Post("/", webhookHandler.Create)
+		r.Get("/{id}", webhookHandler.Get)
+		r.Get("/{id}/deliveries", webhookHandler.Deliveries)
+		r.Get("/{id}/deliveries/{delivery_id}", webhookHandler.Delivery)
+		r.With(idempotent).Post("/{id}/deliveries/{delivery_id}/replay", webhookHandler.Replay)
+
+		r.Group(func(r chi.Router) {
+			r.Use(etag.RequireIfMatch)
+			r.Put("/{id}", webhookHandler.Update)
+			r.Delete("/{id}", webhookHandler.Delete)
+		})
+	})
+
+	oauthHandler := security.NewHandler(ctx, cfg.App, cfg.Auth, securityService)
+	api.With(timeout).Route(cfg.App.Root+"/security", func(r chi.Router) {
+		// nobody is authenticated yet, counted per IP
+		r.Group(func(r chi.Router) {
+			r.Use(tokenLimit)
+			r.Post("/oauth/token", oauthHandler.Auth)
+			r.Post("/oauth/introspect", oauthHandler.Introspect)
+			r.Post("/oauth/revoke", oauthHandler.Revoke)
+		})
+
+		// API keys for the machine clients that cannot do OAuth
+		r.With(middleware.NoCache, ipLimit, authenticate, apiLimit, isAdmin).Route("/apikey", func(r chi.Router) {
+			r.Get("/", oauthHandler.ListKeys)
+			r.Post("/", oauthHandler.CreateKey)
+			r.Post("/{id}/rotate", oauthHandler.RotateKey)
+			r.Delete("/{id}", oauthHandler.RevokeKey)
+		})
+	})
+
+	// public keys and metadata for the services verifying our tokens
+	api.With(utils.CacheControl(5*time.Minute, 10*time.Minute, true)).Route(cfg.App.Root+"/.well-known", func(r chi.Router) {
+		r.Get("/jwks.json", oauthHandler.JWKS)
+		r.Get("/openid-configuration", oauthHandler.Discovery)
+		r.Get("/oauth-authorization-server", oauthHandler.Discovery)
 	})
 	////////// router //////////
 
 	fileServer := http.FileServer(http.Dir("./web"))
 
-	router.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	api.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := "./web" + r.URL.Path
 
 		if _, err := os.Stat(path); err == nil {
@@ -204,7 +337,7 @@ func initialise(ctx context.Context) (*http.Server, error) {
 
 	port := int(cfg.App.Port)
 	slog.Info("starting server on port", slog.Int("port", port))
-	return &http.Server{
+	server := &http.Server{
 		Addr:              ":" + strconv.Itoa(port),
 		ReadTimeout:       15 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
@@ -212,7 +345,11 @@ func initialise(ctx context.Context) (*http.Server, error) {
 		IdleTimeout:       60 * time.Second,
 		MaxHeaderBytes:    1 << 20, // 1 MB
 		Handler:           handlerWithCors,
-	}, nil
+	}
+	// Shutdown waits for active connections to go idle, an open stream never would
+	server.RegisterOnShutdown(playerEvents.Close)
+
+	return server, nil
 }
 
 ////////// CORS //////////
@@ -226,7 +363,14 @@ func cors(next http.Handler) http.Handler {
 		[]string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
 		",")
 	var allowedHeaders = strings.Join(
-		[]string{headers.Authorization, headers.ContentType, headers.IfMatch, headers.IfNoneMatch, headers.XRequestedWith},
+		[]string{headers.Authorization, "X-API-Key", headers.ContentType, headers.IfMatch, headers.IfNoneMatch,
+			headers.IfModifiedSince, headers.IfUnmodifiedSince, headers.XRequestedWith, "Last-Event-ID", idempotency.Header,
+			tracecontext.HeaderTraceparent, tracecontext.HeaderTracestate},
+		",")
+
+	var exposedHeaders = strings.Join(
+		[]string{headers.ETag, "Link", "X-Total-Count", "Accept-Patch", headers.ContentDisposition, headers.RetryAfter, idempotency.HeaderReplayed,
+			"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"},
 		",")
 
 	var allowCredentials = true
@@ -239,7 +383,7 @@ func cors(next http.Handler) http.Handler {
 			w.Header().Set(headers.AccessControlAllowOrigin, origin)
 			w.Header().Set(headers.AccessControlAllowMethods, allowedMethods)
 			w.Header().Set(headers.AccessControlAllowHeaders, allowedHeaders)
-			w.Header().Set(headers.AccessControlExposeHeaders, headers.ETag)
+			w.Header().Set(headers.AccessControlExposeHeaders, exposedHeaders)
 
 			if allowCredentials {
 				w.Header().Set(headers.AccessControlAllowCredentials, "true")
@@ -13,9 +13,15 @@ import (
 )
 
 type Config struct {
-	App  AppConfig
-	DB   DBConfig
-	Auth AuthConfig
+	App         AppConfig
+	DB          DBConfig
+	Auth        AuthConfig
+	Retention   RetentionConfig
+	Reconcile   ReconcileConfig
+	Webhook     WebhookConfig
+	Outbox      OutboxConfig
+	RateLimit   RateLimitConfig
+	Idempotency IdempotencyConfig
 }
 
 type AppConfig struct {
@@ -26,6 +32,7 @@ type AppConfig struct {
 	Root             string
 	BaseUrl          string
 	TimeoutInSeconds time.Duration
+	ImportTimeout    time.Duration // the player import runs outside the service timeout
 	Throttle         uint32
 }
 
@@ -38,10 +45,80 @@ type DBConfig struct {
 	DSN    string
 }
 
+// AuthConfig is the client this service uses for its own calls and the settings of the tokens it issues
 type AuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	TokenURL     string
+	UserID       int      // the user the config client acts as, not the system: its secret sits in the config
+	Scopes       []string // granted to the config client
+	Audience     string
+	TokenTTL     time.Duration
+	FakeToken    bool          // dev only, the token endpoint hands out a fixed fake token
+	PruneEvery   time.Duration // how often revocations of expired tokens are removed
+	Keys         KeyConfig
+}
+
+// KeyConfig controls the keys the access tokens are signed with
+type KeyConfig struct {
+	Alg           string        // EdDSA or RS256, for new keys
+	RotateEvery   time.Duration // age of the newest key before a successor is made
+	CheckEvery    time.Duration // how often the keys are reloaded and checked for rotation
+	EncryptionKey string        // base64 AES-256 key the private keys are stored with, a temporary one in dev when empty
+}
+
+// RateLimitConfig sets the token buckets of the route groups, requests are counted per client, API key or IP
+type RateLimitConfig struct {
+	Enabled bool
+	IP      RateBucket // every authenticated route per IP, before authenticate, so failed attempts count too
+	API     RateBucket // every authenticated route
+	Write   RateBucket // the mutations, on top of API
+	Token   RateBucket // the oauth endpoints, per IP
+}
+
+// RateBucket holds Burst requests and gets PerMinute back
+type RateBucket struct {
+	PerMinute int
+	Burst     int
+}
+
+// IdempotencyConfig controls how long the responses to requests with an Idempotency-Key are replayed
+type IdempotencyConfig struct {
+	Keep       time.Duration
+	PruneEvery time.Duration
+}
+
+// RetentionConfig controls the sweep of soft deleted players
+type RetentionConfig struct {
+	After     time.Duration // how long a player stays in the trash
+	Interval  time.Duration // how often the sweep runs
+	Anonymise bool          // anonymise the rows instead of removing them
+}
+
+// ReconcileConfig controls the sync of the local players with the remote player system
+type ReconcileConfig struct {
+	Enabled  bool
+	Interval time.Duration
+	DryRun   bool // only report the differences
+	PageSize uint // players fetched per remote call
+}
+
+// WebhookConfig controls the delivery of the webhooks
+type WebhookConfig struct {
+	Interval    time.Duration // how often due deliveries are picked up
+	Timeout     time.Duration // per POST
+	MaxAttempts uint          // before a delivery is failed and waits for a replay
+	RetryBase   time.Duration // delay before the second attempt, doubled for each one after that
+}
+
+// OutboxConfig controls the relay of the domain events from the outbox table
+type OutboxConfig struct {
+	Interval  time.Duration
+	BatchSize uint
+	Keep      time.Duration // delivered messages are removed after that
+	Sinks     []string      // log, http, file
+	HTTPUrl   string
+	FilePath  string
 }
 
 func Load() (Config, error) {
@@ -57,6 +134,7 @@ func Load() (Config, error) {
 			Port:             uint16(getInt("APP_PORT", 8080)),
 			Root:             getenv("APP_ROOT", "/"),
 			TimeoutInSeconds: time.Second * time.Duration(getInt("APP_SERVICE_TIMEOUT", 5)),
+			ImportTimeout:    time.Second * time.Duration(getInt("APP_IMPORT_TIMEOUT", 300)),
 			Throttle:         uint32(getInt("APP_THROTTLE", 10)),
 		},
 		DB: DBConfig{
@@ -67,6 +145,54 @@ func Load() (Config, error) {
 			ClientID:     getenvRequired("AUTH_CLIENT_ID"),
 			ClientSecret: getenvRequired("AUTH_CLIENT_SECRET"),
 			TokenURL:     getenvRequired("AUTH_TOKEN_URL"),
+			UserID:       getInt("AUTH_CLIENT_USER_ID", 1000),
+			Scopes:       splitComma("AUTH_CLIENT_SCOPES", []string{"api:read", "api:write"}),
+			Audience:     getenv("AUTH_AUDIENCE", "golab"),
+			TokenTTL:     time.Second * time.Duration(getInt("AUTH_TOKEN_TTL_SECONDS", 3600)),
+			FakeToken:    getBool("AUTH_FAKE_TOKEN", false),
+			PruneEvery:   time.Minute * time.Duration(getInt("AUTH_REVOCATION_PRUNE_MINUTES", 60)),
+			Keys: KeyConfig{
+				Alg:           getenv("AUTH_KEY_ALG", "EdDSA"),
+				RotateEvery:   24 * time.Hour * time.Duration(getInt("AUTH_KEY_ROTATE_DAYS", 30)),
+				CheckEvery:    time.Minute * time.Duration(getInt("AUTH_KEY_CHECK_MINUTES", 10)),
+				EncryptionKey: getenv("AUTH_KEY_ENCRYPTION_KEY", ""),
+			},
+		},
+		Retention: RetentionConfig{
+			After:     24 * time.Hour * time.Duration(getInt("PLAYER_RETENTION_DAYS", 30)),
+			Interval:  time.Minute * time.Duration(getInt("PLAYER_RETENTION_INTERVAL_MINUTES", 60)),
+			Anonymise: getenv("PLAYER_RETENTION_MODE", "anonymise") == "anonymise",
+		},
+		Reconcile: ReconcileConfig{
+			Enabled:  getBool("PLAYER_RECONCILE_ENABLED", false),
+			Interval: time.Minute * time.Duration(getInt("PLAYER_RECONCILE_INTERVAL_MINUTES", 15)),
+			DryRun:   getBool("PLAYER_RECONCILE_DRY_RUN", true),
+			PageSize: uint(getInt("PLAYER_RECONCILE_PAGE_SIZE", 100)),
+		},
+		Webhook: WebhookConfig{
+			Interval:    time.Second * time.Duration(getInt("WEBHOOK_INTERVAL_SECONDS", 5)),
+			Timeout:     time.Second * time.Duration(getInt("WEBHOOK_TIMEOUT_SECONDS", 10)),
+			MaxAttempts: uint(getInt("WEBHOOK_MAX_ATTEMPTS", 8)),
+			RetryBase:   time.Second * time.Duration(getInt("WEBHOOK_RETRY_BASE_SECONDS", 30)),
+		},
+		Outbox: OutboxConfig{
+			Interval:  time.Second * time.Duration(getInt("OUTBOX_INTERVAL_SECONDS", 2)),
+			BatchSize: uint(getInt("OUTBOX_BATCH_SIZE", 100)),
+			Keep:      time.Hour * time.Duration(getInt("OUTBOX_KEEP_HOURS", 72)),
+			Sinks:     splitComma("OUTBOX_SINKS", []string{"log"}),
+			HTTPUrl:   getenv("OUTBOX_HTTP_URL", ""),
+			FilePath:  getenv("OUTBOX_FILE_PATH", "./outbox.ndjson"),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: getBool("RATELIMIT_ENABLED", true),
+			IP:      RateBucket{PerMinute: getInt("RATELIMIT_IP_PER_MINUTE", 1200), Burst: getInt("RATELIMIT_IP_BURST", 200)},
+			API:     RateBucket{PerMinute: getInt("RATELIMIT_API_PER_MINUTE", 600), Burst: getInt("RATELIMIT_API_BURST", 100)},
+			Write:   RateBucket{PerMinute: getInt("RATELIMIT_WRITE_PER_MINUTE", 120), Burst: getInt("RATELIMIT_WRITE_BURST", 20)},
+			Token:   RateBucket{PerMinute: getInt("RATELIMIT_TOKEN_PER_MINUTE", 30), Burst: getInt("RATELIMIT_TOKEN_BURST", 10)},
+		},
+		Idempotency: IdempotencyConfig{
+			Keep:       time.Hour * time.Duration(getInt("IDEMPOTENCY_KEEP_HOURS", 24)),
+			PruneEvery: time.Minute * time.Duration(getInt("IDEMPOTENCY_PRUNE_MINUTES", 60)),
 		},
 	}
 	cfg.App.BaseUrl = fmt.Sprintf("%s://%s:%d%s", cfg.App.Protocol, cfg.App.Host, cfg.App.Port, cfg.App.Root)
@@ -136,3 +262,15 @@ func splitComma(key string, def []string) []string {
 	}
 	return commaRegex.Split(v, -1)
 }
+
+func getBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid bool for %s: %v", key, err)
+	}
+	return b
+}
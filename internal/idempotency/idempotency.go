@@ -0,0 +1,253 @@
+package idempotency
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	Header         = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	maxKey  = 255
+	maxBody = 1 << 20
+)
+
+// replayedHeaders are kept with the response, the ETag gives the client the If-Match of its next write
+var replayedHeaders = []string{headers.ContentType, headers.ETag, headers.Location}
+
+var (
+	ErrKeyReused = problem.NewError(http.StatusUnprocessableEntity, "the Idempotency-Key was used for a different request")
+
+	// errNotKept rolls back a request that failed, it may be retried with the same key
+	errNotKept = errors.New("response not kept")
+)
+
+// Keeper replays the response to a request that carried an Idempotency-Key when the request is sent again.
+// The key is claimed, the request handled and its response stored in one transaction, which the services
+// called by the handler join, so the change and the key are committed together or not at all.
+type Keeper struct {
+	db  *dbutils.DbUtils
+	cfg config.IdempotencyConfig
+}
+
+func NewKeeper(dbUtils *dbutils.DbUtils, cfg config.IdempotencyConfig) *Keeper {
+	if err := validate.Get().Var(dbUtils, "required"); err != nil {
+		panic(err)
+	}
+	return &Keeper{
+		db:  dbUtils,
+		cfg: cfg,
+	}
+}
+
+// Handler is the middleware, after authenticate since keys are per user. Only for handlers that stay on one
+// goroutine, the transaction cannot be shared. Requests without the header pass as they are.
+func (k *Keeper) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKey || strings.TrimSpace(key) == "" {
+			problem.WriteStatus(w, r, http.StatusBadRequest, "Idempotency-Key must have 1 to 255 characters")
+			return
+		}
+
+		ctx := r.Context()
+		userId, ok := session.UserIDFromContext(ctx)
+		if !ok {
+			problem.WriteStatus(w, r, http.StatusUnauthorized, "Idempotency-Key needs an authenticated request")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+		if err != nil {
+			problem.WriteStatus(w, r, http.StatusRequestEntityTooLarge, "the body is too large for an Idempotency-Key")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fp := fingerprint(r.Method, r.URL.Path, body)
+
+		rec := newRecorder()
+		var replay *stored
+		// what the services queued for after the commit, see dbutils.AfterCommit
+		txCtx := ctx
+
+		err = k.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+			repo, err := NewRepo(tx)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			claimed, err := repo.Claim(ctx, userId, key, fp, now, now.Add(k.cfg.Keep))
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				replay, err = repo.Find(ctx, userId, key)
+				if err != nil {
+					return err
+				}
+				if replay.Fingerprint != fp {
+					return ErrKeyReused
+				}
+				return nil
+			}
+
+			txCtx = dbutils.ContextWithTx(ctx, tx)
+			next.ServeHTTP(rec, r.WithContext(txCtx))
+
+			if rec.status >= http.StatusBadRequest {
+				return errNotKept
+			}
+			kept, err := keptHeaders(rec.header)
+			if err != nil {
+				return err
+			}
+			return repo.Complete(ctx, userId, key, rec.status, kept, rec.body.Bytes())
+		})
+		switch {
+		case errors.Is(err, errNotKept):
+			rec.flush(w)
+		case err != nil:
+			problem.Write(w, r, err)
+		case replay != nil:
+			slog.InfoContext(ctx, "idempotent request replayed", "key", key, "user_id", userId, "method", r.Method, "path", r.URL.Path)
+			writeReplay(ctx, w, replay)
+		default:
+			dbutils.Committed(txCtx)
+			rec.flush(w)
+		}
+	})
+}
+
+// Prune removes the expired keys
+func (k *Keeper) Prune(ctx context.Context) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	var pruned int64
+
+	err := k.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		pruned, err = repo.Prune(ctx, time.Now())
+		return err
+	})
+
+	return pruned, err
+}
+
+// NewPruneService removes the expired keys on a schedule
+func NewPruneService(k *Keeper) utils.Service {
+	return utils.NewScheduledService("idempotency-prune", k.cfg.PruneEvery, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+		pruned, err := k.Prune(ctx)
+		if err != nil {
+			return err
+		}
+
+		if pruned > 0 {
+			slog.InfoContext(ctx, "idempotency keys pruned", "removed", pruned)
+		}
+		return nil
+	})
+}
+
+// fingerprint tells requests apart that reuse a key
+func fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// keptHeaders are the replayedHeaders of the response, as JSON
+func keptHeaders(h http.Header) ([]byte, error) {
+	kept := http.Header{}
+	for _, name := range replayedHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			kept[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return json.Marshal(kept)
+}
+
+func writeReplay(ctx context.Context, w http.ResponseWriter, s *stored) {
+	if len(s.Headers) > 0 {
+		var kept http.Header
+		if err := json.Unmarshal(s.Headers, &kept); err != nil {
+			slog.WarnContext(ctx, "idempotency: stored headers unreadable, replayed without", "error", err)
+		}
+		for name, values := range kept {
+			for _, v := range values {
+				w.Header().Add(name, v)
+			}
+		}
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(int(s.Status.Int32))
+	_, _ = w.Write(s.Body)
+}
+
+// recorder holds the response until the transaction is committed
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: http.Header{}, status: http.StatusOK}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status, r.wroteHeader = status, true
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
+
+func (r *recorder) flush(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}
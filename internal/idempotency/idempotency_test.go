@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/session"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	req := require.New(t)
+
+	fp := fingerprint(http.MethodPost, "/lab/player", []byte(`{"name":"a"}`))
+	req.Len(fp, 64)
+	req.Equal(fp, fingerprint(http.MethodPost, "/lab/player", []byte(`{"name":"a"}`)))
+	req.NotEqual(fp, fingerprint(http.MethodPost, "/lab/player", []byte(`{"name":"b"}`)))
+	req.NotEqual(fp, fingerprint(http.MethodPut, "/lab/player", []byte(`{"name":"a"}`)))
+	req.NotEqual(fp, fingerprint(http.MethodPost, "/lab/player/1/restore", []byte(`{"name":"a"}`)))
+}
+
+func TestRecorder(t *testing.T) {
+	req := require.New(t)
+
+	rec := newRecorder()
+	rec.Header().Set(headers.ContentType, "application/json")
+	rec.WriteHeader(http.StatusCreated)
+	rec.WriteHeader(http.StatusInternalServerError)
+	_, _ = rec.Write([]byte("42"))
+
+	w := httptest.NewRecorder()
+	rec.flush(w)
+	req.Equal(http.StatusCreated, w.Code)
+	req.Equal("application/json", w.Header().Get(headers.ContentType))
+	req.Equal("42", w.Body.String())
+
+	// a body without a status is a 200
+	rec = newRecorder()
+	_, _ = rec.Write([]byte("ok"))
+	rec.WriteHeader(http.StatusTeapot)
+	req.Equal(http.StatusOK, rec.status)
+}
+
+func TestReplay(t *testing.T) {
+	req := require.New(t)
+
+	h := http.Header{}
+	h.Set(headers.ContentType, "application/json")
+	h.Set(headers.ETag, `W/"1700000000000000000"`)
+	h.Set(headers.Location, "/lab/player/42")
+	h.Set(headers.SetCookie, "session=abc")
+	kept, err := keptHeaders(h)
+	req.NoError(err)
+
+	w := httptest.NewRecorder()
+	writeReplay(context.Background(), w, &stored{
+		Status:  sql.NullInt32{Int32: http.StatusCreated, Valid: true},
+		Headers: kept,
+		Body:    []byte("42"),
+	})
+	req.Equal(http.StatusCreated, w.Code)
+	req.Equal("true", w.Header().Get(HeaderReplayed))
+	req.Equal("application/json", w.Header().Get(headers.ContentType))
+	req.Equal(`W/"1700000000000000000"`, w.Header().Get(headers.ETag))
+	req.Equal("/lab/player/42", w.Header().Get(headers.Location))
+	req.Empty(w.Header().Get(headers.SetCookie))
+	req.Equal("42", w.Body.String())
+}
+
+func TestHandler(t *testing.T) {
+	req := require.New(t)
+
+	// none of these get to the database
+	k := &Keeper{}
+	h := k.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	call := func(key string, user bool) int {
+		r := httptest.NewRequest(http.MethodPost, "/player", strings.NewReader("{}"))
+		if key != "" {
+			r.Header.Set(Header, key)
+		}
+		if user {
+			r = r.WithContext(session.ContextWithUserID(r.Context(), 1001))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	req.Equal(http.StatusNoContent, call("", true))
+	req.Equal(http.StatusBadRequest, call("  ", true))
+	req.Equal(http.StatusBadRequest, call(strings.Repeat("k", maxKey+1), true))
+	req.Equal(http.StatusUnauthorized, call("k1", false))
+}
+
+func TestAfterCommit(t *testing.T) {
+	req := require.New(t)
+
+	var ran []string
+
+	// a transaction of its own has committed already
+	dbutils.AfterCommit(context.Background(), func() { ran = append(ran, "own") })
+	req.Equal([]string{"own"}, ran)
+
+	// a joined one waits for its owner
+	ctx := dbutils.ContextWithTx(context.Background(), nil)
+	dbutils.AfterCommit(ctx, func() { ran = append(ran, "joined") })
+	req.Equal([]string{"own"}, ran)
+
+	dbutils.Committed(ctx)
+	req.Equal([]string{"own", "joined"}, ran)
+	dbutils.Committed(ctx)
+	req.Equal([]string{"own", "joined"}, ran)
+}
@@ -0,0 +1,148 @@
+package idempotency
+
+import (
+	"Go-lab/internal/utils/validate"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Repo struct {
+	tx *sqlx.Tx
+}
+
+func NewRepo(tx *sqlx.Tx) (*Repo, error) {
+	if err := validate.Get().Var(tx, "required"); err != nil {
+		return nil, fmt.Errorf("invalid tx: %w", err)
+	}
+
+	return &Repo{tx: tx}, nil
+}
+
+// stored is a row of idempotency_key, Status is only NULL inside the transaction that claimed the key
+type stored struct {
+	Fingerprint string        `db:"fingerprint"`
+	Status      sql.NullInt32 `db:"status"`
+	Headers     []byte        `db:"headers"`
+	Body        []byte        `db:"body"`
+}
+
+// Claim inserts the key unless it is there already. The row stays locked until the transaction ends, so a
+// second request with the key waits for the first one to commit or roll back. An expired key is free again.
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Claim(ctx context.Context, userId int, key, fingerprint string, now, expiresAt time.Time) (bool, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return false, err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, `
+		DELETE FROM
+			idempotency_key
+		WHERE
+			user_id = ?
+		AND
+			idem_key = ?
+		AND
+			expires_at < ?`,
+		userId, key, now,
+	); err != nil {
+		return false, fmt.Errorf("delete expired idempotency key: %w", err)
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		INSERT INTO idempotency_key
+			(user_id, idem_key, fingerprint, expires_at)
+		VALUES
+			(?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			idem_key = idem_key`,
+		userId, key, fingerprint, expiresAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("insert idempotency key: %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("rows affected check for idempotency key: %w", err)
+	}
+	return affected == 1, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Find(ctx context.Context, userId int, key string) (*stored, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var s stored
+
+	if err := r.tx.GetContext(ctx, &s, `
+		SELECT
+			fingerprint,
+			status,
+			headers,
+			body
+		FROM
+			idempotency_key
+		WHERE
+			user_id = ?
+		AND
+			idem_key = ?`,
+		userId, key,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Complete stores the response to the request that claimed the key
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Complete(ctx context.Context, userId int, key string, status int, headers, body []byte) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			idempotency_key
+		SET
+			status = ?,
+			headers = ?,
+			body = ?
+		WHERE
+			user_id = ?
+		AND
+			idem_key = ?`,
+		status, headers, body, userId, key,
+	); err != nil {
+		return fmt.Errorf("complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Prune(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		DELETE FROM
+			idempotency_key
+		WHERE
+			expires_at < ?`,
+		expiredBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("prune idempotency keys: %w", err)
+	}
+
+	return res.RowsAffected()
+}
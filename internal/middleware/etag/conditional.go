@@ -0,0 +1,162 @@
+package etag
+
+import (
+	"Go-lab/internal/utils/problem"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-http-utils/headers"
+)
+
+// ETag is a single entity tag, W/"123" or "abc"
+type ETag struct {
+	Value string
+	Weak  bool
+}
+
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Value + `"`
+	}
+	return `"` + e.Value + `"`
+}
+
+// Strong is the comparison If-Match uses: both tags strong and equal
+func (e ETag) Strong(o ETag) bool {
+	return !e.Weak && !o.Weak && e.Value == o.Value
+}
+
+// WeakMatch is the comparison If-None-Match uses: equal values, weakness ignored
+func (e ETag) WeakMatch(o ETag) bool {
+	return e.Value == o.Value
+}
+
+// Parse reads a single entity tag
+func Parse(s string) (ETag, bool) {
+	tags, star := ParseList(s)
+	if star || len(tags) != 1 {
+		return ETag{}, false
+	}
+	return tags[0], true
+}
+
+// ParseList reads the value of an If-Match or If-None-Match header: either "*" or a comma separated list of tags.
+// Malformed members are skipped.
+func ParseList(h string) ([]ETag, bool) {
+	h = strings.TrimSpace(h)
+	if h == "*" {
+		return nil, true
+	}
+
+	var tags []ETag
+	for h != "" {
+		h = strings.TrimLeft(h, " \t,")
+
+		weak := strings.HasPrefix(h, "W/")
+		if weak {
+			h = h[2:]
+		}
+		if !strings.HasPrefix(h, `"`) {
+			// skip to the next member
+			if i := strings.IndexByte(h, ','); i >= 0 {
+				h = h[i+1:]
+				continue
+			}
+			break
+		}
+
+		end := strings.IndexByte(h[1:], '"')
+		if end < 0 {
+			break
+		}
+		tags = append(tags, ETag{Value: h[1 : end+1], Weak: weak})
+		h = h[end+2:]
+	}
+
+	return tags, false
+}
+
+// Validators describe the current representation of a resource. An empty ETag means there is none.
+type Validators struct {
+	ETag         string
+	LastModified *time.Time
+}
+
+// Evaluate runs the preconditions of the request against the validators in the order of RFC 9110 section 13.2.2.
+// It returns 0 when the request should proceed, http.StatusNotModified or http.StatusPreconditionFailed otherwise.
+func Evaluate(r *http.Request, v Validators) int {
+	current, hasCurrent := Parse(v.ETag)
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	if h := r.Header.Get(headers.IfMatch); h != "" {
+		tags, star := ParseList(h)
+		if !matches(tags, star, current, hasCurrent, ETag.Strong) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := httpDate(r.Header.Get(headers.IfUnmodifiedSince)); ok && v.LastModified != nil {
+		if v.LastModified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if h := r.Header.Get(headers.IfNoneMatch); h != "" {
+		tags, star := ParseList(h)
+		if matches(tags, star, current, hasCurrent, ETag.WeakMatch) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := httpDate(r.Header.Get(headers.IfModifiedSince)); ok && safe && v.LastModified != nil {
+		if !v.LastModified.Truncate(time.Second).After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// Check sets the ETag and Last-Modified headers and evaluates the preconditions.
+// It returns true when it has answered the request with a 304 or 412.
+func Check(w http.ResponseWriter, r *http.Request, v Validators) bool {
+	if v.ETag != "" {
+		w.Header().Set(headers.ETag, v.ETag)
+	}
+	if v.LastModified != nil && !v.LastModified.IsZero() {
+		w.Header().Set(headers.LastModified, v.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	switch Evaluate(r, v) {
+	case http.StatusNotModified:
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	case http.StatusPreconditionFailed:
+		problem.WriteStatus(w, r, http.StatusPreconditionFailed, "the resource does not match the request preconditions")
+		return true
+	}
+	return false
+}
+
+func matches(tags []ETag, star bool, current ETag, hasCurrent bool, cmp func(ETag, ETag) bool) bool {
+	if !hasCurrent {
+		return false
+	}
+	if star {
+		return true
+	}
+	for _, tag := range tags {
+		if cmp(tag, current) {
+			return true
+		}
+	}
+	return false
+}
+
+func httpDate(s string) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(s)
+	return t, err == nil
+}
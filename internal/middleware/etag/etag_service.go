@@ -1,8 +1,12 @@
 package etag
 
 import (
+	"Go-lab/internal/utils/problem"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -10,16 +14,10 @@ import (
 	"github.com/go-http-utils/headers"
 )
 
+// HandleConditionalGet answers a GET of a versioned resource with a 304 when the client's copy is current.
+// The version doubles as the Last-Modified date.
 func HandleConditionalGet(w http.ResponseWriter, r *http.Request, version *time.Time) bool {
-	etag := MakeWeakETag(version)
-
-	if match := r.Header.Get(headers.IfNoneMatch); match == etag {
-		w.WriteHeader(http.StatusNotModified)
-		return true
-	}
-
-	w.Header().Set(headers.ETag, etag)
-	return false
+	return Check(w, r, Validators{ETag: MakeWeakETag(version), LastModified: version})
 }
 
 func MakeWeakETag(t *time.Time) string {
@@ -29,25 +27,85 @@ func MakeWeakETag(t *time.Time) string {
 	return `W/"` + strconv.FormatInt(t.UnixMicro(), 10) + `"`
 }
 
-func ParseETag(h string) (*time.Time, error) {
-	if h == "" {
-		return nil, fmt.Errorf("missing ETag")
+// MakeCollectionETag builds a weak ETag for a listing from the row count, the newest change and the request
+// parameters, so any insert, update, delete or different page of the same collection gets a new tag.
+func MakeCollectionETag(count uint, lastModified *time.Time, params url.Values) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", count)
+	if lastModified != nil {
+		fmt.Fprintf(h, "%d\n", lastModified.UnixMicro())
 	}
+	h.Write([]byte(params.Encode())) // sorted by key
 
-	// Strip weak validator prefix if present
-	h = strings.TrimPrefix(h, "W/")
+	return ETag{Value: hex.EncodeToString(h.Sum(nil)[:16]), Weak: true}.String()
+}
 
-	v := strings.Trim(h, `"`)
+// Versions are the versions an If-Match header names. Any is If-Match: *, which every current representation
+// matches; otherwise the request goes ahead when the row's version is one of List.
+type Versions struct {
+	Any  bool
+	List []*time.Time
+}
+
+// Exact is the precondition of a caller that has seen the one version, nil for a NULL updated_at
+func Exact(version *time.Time) Versions {
+	return Versions{List: []*time.Time{version}}
+}
+
+// Predicate is the SQL condition matching the column against the versions, with its arguments. Every version
+// gets a NULL-safe comparison; a list without any version the repos could have issued never matches.
+func (v Versions) Predicate(column string) (string, []any) {
+	if v.Any {
+		return "TRUE", nil
+	}
+	if len(v.List) == 0 {
+		return "FALSE", nil
+	}
 
-	if v == "0" {
-		return nil, nil // represents NULL updated_at
+	conditions := make([]string, len(v.List))
+	args := make([]any, len(v.List))
+	for i, version := range v.List {
+		conditions[i] = column + " <=> ?"
+		args[i] = version
 	}
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// Version reads the If-Match header of a mutating request. A missing header is a 428 Precondition Required.
+func Version(r *http.Request) (Versions, error) {
+	return ParseETag(r.Header.Get(headers.IfMatch))
+}
+
+// ParseETag turns the version ETags made by MakeWeakETag back into timestamps, or "*" into Versions.Any. The
+// version is compared in the database, so weak and strong forms are both accepted. A tag that is no version
+// of ours cannot match and is left out, the repos then answer as for any other stale version.
+func ParseETag(h string) (Versions, error) {
+	if h == "" {
+		return Versions{}, fmt.Errorf("%w: If-Match is required", problem.ErrPreconditionRequired)
+	}
+
+	tags, star := ParseList(h)
+	if star {
+		return Versions{Any: true}, nil
+	}
+	if len(tags) == 0 {
+		return Versions{}, fmt.Errorf("%w: malformed If-Match", problem.ErrBadRequest)
+	}
+
+	var versions Versions
+	for _, tag := range tags {
+		if tag.Value == "0" {
+			versions.List = append(versions.List, nil) // represents NULL updated_at
+			continue
+		}
 
-	micro, err := strconv.ParseInt(v, 10, 64)
-	if err != nil {
-		return &time.Time{}, err
+		micro, err := strconv.ParseInt(tag.Value, 10, 64)
+		if err != nil {
+			continue
+		}
+		t := time.UnixMicro(micro)
+		versions.List = append(versions.List, &t)
 	}
 
-	t := time.UnixMicro(micro)
-	return &t, nil
+	return versions, nil
 }
@@ -0,0 +1,181 @@
+package etag
+
+import (
+	"Go-lab/internal/utils/problem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseList(t *testing.T) {
+	req := require.New(t)
+
+	tags, star := ParseList(`W/"1", "a,b" ,junk, "c"`)
+	req.False(star)
+	req.Equal([]ETag{{Value: "1", Weak: true}, {Value: "a,b"}, {Value: "c"}}, tags)
+
+	_, star = ParseList(" * ")
+	req.True(star)
+
+	tag, ok := Parse(`W/"42"`)
+	req.True(ok)
+	req.Equal(`W/"42"`, tag.String())
+
+	_, ok = Parse(`"1", "2"`)
+	req.False(ok)
+}
+
+func TestCompare(t *testing.T) {
+	req := require.New(t)
+
+	weak, strong := ETag{Value: "1", Weak: true}, ETag{Value: "1"}
+	req.True(strong.Strong(strong))
+	req.False(weak.Strong(strong))
+	req.False(weak.Strong(weak))
+	req.True(weak.WeakMatch(strong))
+	req.False(weak.WeakMatch(ETag{Value: "2"}))
+}
+
+func TestEvaluate(t *testing.T) {
+	req := require.New(t)
+
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	v := Validators{ETag: `"abc"`, LastModified: &modified}
+
+	request := func(method string, h ...string) *http.Request {
+		r := httptest.NewRequest(method, "/thing", nil)
+		for i := 0; i < len(h); i += 2 {
+			r.Header.Set(h[i], h[i+1])
+		}
+		return r
+	}
+
+	req.Equal(0, Evaluate(request(http.MethodGet), v))
+	req.Equal(http.StatusNotModified, Evaluate(request(http.MethodGet, headers.IfNoneMatch, `"x", W/"abc"`), v))
+	req.Equal(http.StatusNotModified, Evaluate(request(http.MethodGet, headers.IfNoneMatch, `*`), v))
+	req.Equal(0, Evaluate(request(http.MethodGet, headers.IfNoneMatch, `*`), Validators{}))
+	req.Equal(http.StatusPreconditionFailed, Evaluate(request(http.MethodPut, headers.IfNoneMatch, `*`), v))
+
+	req.Equal(0, Evaluate(request(http.MethodPut, headers.IfMatch, `"abc"`), v))
+	req.Equal(http.StatusPreconditionFailed, Evaluate(request(http.MethodPut, headers.IfMatch, `W/"abc"`), v))
+	req.Equal(http.StatusPreconditionFailed, Evaluate(request(http.MethodPut, headers.IfMatch, `*`), Validators{}))
+
+	at := modified.Format(http.TimeFormat)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	req.Equal(http.StatusNotModified, Evaluate(request(http.MethodGet, headers.IfModifiedSince, at), v))
+	req.Equal(0, Evaluate(request(http.MethodGet, headers.IfModifiedSince, before), v))
+	// If-None-Match wins over If-Modified-Since
+	req.Equal(0, Evaluate(request(http.MethodGet, headers.IfNoneMatch, `"x"`, headers.IfModifiedSince, at), v))
+
+	req.Equal(0, Evaluate(request(http.MethodDelete, headers.IfUnmodifiedSince, at), v))
+	req.Equal(http.StatusPreconditionFailed, Evaluate(request(http.MethodDelete, headers.IfUnmodifiedSince, before), v))
+}
+
+func TestParseETag(t *testing.T) {
+	req := require.New(t)
+
+	now := time.UnixMicro(time.Now().UnixMicro())
+	versions, err := ParseETag(MakeWeakETag(&now))
+	req.NoError(err)
+	req.False(versions.Any)
+	req.Len(versions.List, 1)
+	req.True(now.Equal(*versions.List[0]))
+
+	versions, err = ParseETag(`W/"0"`)
+	req.NoError(err)
+	req.Equal(Versions{List: []*time.Time{nil}}, versions)
+	req.Equal(Exact(nil), versions)
+
+	// any current representation
+	versions, err = ParseETag(" * ")
+	req.NoError(err)
+	req.True(versions.Any)
+
+	// a list, each tag is tried
+	versions, err = ParseETag(`W/"0", "` + strconv.FormatInt(now.UnixMicro(), 10) + `"`)
+	req.NoError(err)
+	req.Len(versions.List, 2)
+	req.Nil(versions.List[0])
+	req.True(now.Equal(*versions.List[1]))
+
+	// none of them is a version of ours, nothing matches
+	versions, err = ParseETag(`W/"a", W/"b"`)
+	req.NoError(err)
+	req.False(versions.Any)
+	req.Empty(versions.List)
+
+	_, err = ParseETag("")
+	req.True(errors.Is(err, problem.ErrPreconditionRequired))
+
+	_, err = ParseETag("junk")
+	req.True(errors.Is(err, problem.ErrBadRequest))
+}
+
+func TestPredicate(t *testing.T) {
+	req := require.New(t)
+
+	now := time.Now()
+
+	predicate, args := Versions{Any: true}.Predicate("updated_at")
+	req.Equal("TRUE", predicate)
+	req.Empty(args)
+
+	predicate, args = Versions{}.Predicate("updated_at")
+	req.Equal("FALSE", predicate)
+	req.Empty(args)
+
+	predicate, args = Versions{List: []*time.Time{nil, &now}}.Predicate("updated_at")
+	req.Equal("(updated_at <=> ? OR updated_at <=> ?)", predicate)
+	req.Equal([]any{(*time.Time)(nil), &now}, args)
+}
+
+func TestMakeCollectionETag(t *testing.T) {
+	req := require.New(t)
+
+	now := time.Now()
+	later := now.Add(time.Microsecond)
+	params := url.Values{"sort": {"name"}, "limit": {"10"}}
+
+	tag := MakeCollectionETag(3, &now, params)
+	req.Equal(tag, MakeCollectionETag(3, &now, url.Values{"limit": {"10"}, "sort": {"name"}}))
+	req.NotEqual(tag, MakeCollectionETag(2, &now, params))
+	req.NotEqual(tag, MakeCollectionETag(3, &later, params))
+	req.NotEqual(tag, MakeCollectionETag(3, &now, url.Values{"sort": {"name"}, "limit": {"20"}}))
+	req.NotEqual(tag, MakeCollectionETag(3, nil, params))
+
+	parsed, ok := Parse(tag)
+	req.True(ok)
+	req.True(parsed.Weak)
+}
+
+func TestMiddleware(t *testing.T) {
+	req := require.New(t)
+
+	body := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hello":"world"}`))
+	})
+
+	w := httptest.NewRecorder()
+	Conditional(body).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	req.Equal(http.StatusOK, w.Code)
+	tag := w.Header().Get(headers.ETag)
+	req.NotEmpty(tag)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(headers.IfNoneMatch, tag)
+	w = httptest.NewRecorder()
+	Conditional(body).ServeHTTP(w, r)
+	req.Equal(http.StatusNotModified, w.Code)
+	req.Empty(w.Body.String())
+
+	w = httptest.NewRecorder()
+	RequireIfMatch(body).ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", nil))
+	req.Equal(http.StatusPreconditionRequired, w.Code)
+}
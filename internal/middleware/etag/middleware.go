@@ -0,0 +1,79 @@
+package etag
+
+import (
+	"Go-lab/internal/utils/problem"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-http-utils/headers"
+)
+
+// RequireIfMatch rejects unsafe requests that carry no If-Match with a 428 Precondition Required,
+// so lost updates cannot happen by omission.
+func RequireIfMatch(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			if r.Header.Get(headers.IfMatch) == "" {
+				problem.WriteStatus(w, r, http.StatusPreconditionRequired, "If-Match is required")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Conditional gives GET and HEAD responses that have no validators of their own a strong ETag hashed from the
+// body, and answers If-None-Match and If-Match from it. The response is buffered, so it is for small resources.
+func Conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		if buf.status != http.StatusOK || w.Header().Get(headers.ETag) != "" {
+			buf.flush()
+			return
+		}
+
+		sum := sha256.Sum256(buf.body.Bytes())
+		v := Validators{ETag: ETag{Value: hex.EncodeToString(sum[:16])}.String()}
+		if lm, err := http.ParseTime(w.Header().Get(headers.LastModified)); err == nil {
+			v.LastModified = &lm
+		}
+
+		if Check(w, r, v) {
+			return
+		}
+		buf.flush()
+	})
+}
+
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+func (b *bufferedWriter) flush() {
+	b.ResponseWriter.WriteHeader(b.status)
+	if _, err := b.ResponseWriter.Write(b.body.Bytes()); err != nil {
+		slog.Error("failed to write buffered response", "error", err)
+	}
+}
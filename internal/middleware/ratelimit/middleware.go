@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils/problem"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-http-utils/headers"
+)
+
+// the fields of draft-ietf-httpapi-ratelimit-headers
+const (
+	headerLimit     = "RateLimit-Limit"
+	headerRemaining = "RateLimit-Remaining"
+	headerReset     = "RateLimit-Reset"
+	headerPolicy    = "RateLimit-Policy"
+)
+
+// KeyFunc names the bucket of the request: the client, the API key or the IP
+type KeyFunc func(r *http.Request) string
+
+// ByIP keys on the address of the caller, behind middleware.RealIP that is the one of the proxy headers
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Limiter hands out the middlewares of the route groups, all counting in the same store
+type Limiter struct {
+	cfg   config.RateLimitConfig
+	store Store
+	key   KeyFunc
+	now   func() time.Time
+}
+
+func NewLimiter(cfg config.RateLimitConfig, store Store, key KeyFunc) *Limiter {
+	return &Limiter{
+		cfg:   cfg,
+		store: store,
+		key:   key,
+		now:   time.Now,
+	}
+}
+
+// Group limits the routes it is used on to bucket, per key. The name keeps the buckets of the groups apart.
+// A disabled limiter or an empty bucket lets everything through.
+func (l *Limiter) Group(name string, bucket config.RateBucket) func(http.Handler) http.Handler {
+	if !l.cfg.Enabled || bucket.PerMinute <= 0 || bucket.Burst <= 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	limit := Limit{Burst: bucket.Burst, Rate: float64(bucket.PerMinute) / 60}
+	policy := fmt.Sprintf("%d;w=%d", bucket.Burst, int(math.Ceil(float64(bucket.Burst)/limit.Rate)))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := l.key(r)
+
+			res, err := l.store.Take(r.Context(), name+"|"+key, limit, l.now())
+			if err != nil {
+				// better to serve too much than nothing
+				slog.ErrorContext(r.Context(), "rate limit store", "group", name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set(headerLimit, strconv.Itoa(bucket.Burst))
+			w.Header().Set(headerRemaining, strconv.Itoa(res.Remaining))
+			w.Header().Set(headerReset, strconv.Itoa(ceilSeconds(res.Reset)))
+			w.Header().Set(headerPolicy, policy)
+
+			if !res.Allowed {
+				slog.InfoContext(r.Context(), "rate limited", "group", name, "key", key, "method", r.Method, "path", r.URL.Path)
+				w.Header().Set(headers.RetryAfter, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				problem.Write(w, r, problem.ErrTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"Go-lab/config"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	req := require.New(t)
+
+	s := NewMemoryStore()
+	limit := Limit{Burst: 2, Rate: 1}
+	now := time.Now()
+
+	res, err := s.Take(context.Background(), "a", limit, now)
+	req.NoError(err)
+	req.True(res.Allowed)
+	req.Equal(1, res.Remaining)
+	req.Equal(time.Second, res.Reset)
+
+	res, _ = s.Take(context.Background(), "a", limit, now)
+	req.True(res.Allowed)
+	req.Equal(0, res.Remaining)
+
+	// empty, a token comes back every second
+	res, _ = s.Take(context.Background(), "a", limit, now)
+	req.False(res.Allowed)
+	req.Equal(time.Second, res.RetryAfter)
+	req.Equal(2*time.Second, res.Reset)
+
+	res, _ = s.Take(context.Background(), "a", limit, now.Add(500*time.Millisecond))
+	req.False(res.Allowed)
+	req.Equal(500*time.Millisecond, res.RetryAfter)
+
+	res, _ = s.Take(context.Background(), "a", limit, now.Add(time.Second))
+	req.True(res.Allowed)
+
+	// other keys have their own bucket
+	res, _ = s.Take(context.Background(), "b", limit, now)
+	req.True(res.Allowed)
+	req.Equal(1, res.Remaining)
+
+	// buckets that filled up again are swept
+	_, _ = s.Take(context.Background(), "c", limit, now.Add(time.Hour))
+	req.Len(s.buckets, 1)
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("down")
+}
+
+func TestGroup(t *testing.T) {
+	req := require.New(t)
+
+	cfg := config.RateLimitConfig{Enabled: true}
+	l := NewLimiter(cfg, NewMemoryStore(), ByIP)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := l.Group("api", config.RateBucket{PerMinute: 60, Burst: 2})(ok)
+
+	call := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/player", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("10.0.0.1:1234")
+	req.Equal(http.StatusNoContent, w.Code)
+	req.Equal("2", w.Header().Get("RateLimit-Limit"))
+	req.Equal("1", w.Header().Get("RateLimit-Remaining"))
+	req.Equal("1", w.Header().Get("RateLimit-Reset"))
+	req.Equal("2;w=2", w.Header().Get("RateLimit-Policy"))
+
+	// the port does not matter
+	req.Equal(http.StatusNoContent, call("10.0.0.1:5678").Code)
+
+	w = call("10.0.0.1:1234")
+	req.Equal(http.StatusTooManyRequests, w.Code)
+	req.Equal("1", w.Header().Get(headers.RetryAfter))
+	req.Equal("0", w.Header().Get("RateLimit-Remaining"))
+	req.Contains(w.Header().Get(headers.ContentType), "problem+json")
+
+	req.Equal(http.StatusNoContent, call("10.0.0.2:1234").Code)
+
+	now = now.Add(time.Second)
+	req.Equal(http.StatusNoContent, call("10.0.0.1:1234").Code)
+
+	// disabled, or an empty bucket
+	l = NewLimiter(config.RateLimitConfig{Enabled: false}, NewMemoryStore(), ByIP)
+	req.Equal(http.StatusNoContent, serve(l.Group("api", config.RateBucket{PerMinute: 1, Burst: 1})(ok)).Code)
+	l = NewLimiter(cfg, NewMemoryStore(), ByIP)
+	req.Equal(http.StatusNoContent, serve(l.Group("api", config.RateBucket{})(ok)).Code)
+
+	// a failing store lets the request through
+	l = NewLimiter(cfg, failingStore{}, ByIP)
+	w = serve(l.Group("api", config.RateBucket{PerMinute: 1, Burst: 1})(ok))
+	req.Equal(http.StatusNoContent, w.Code)
+	req.Empty(w.Header().Get("RateLimit-Limit"))
+}
+
+func serve(h http.Handler) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
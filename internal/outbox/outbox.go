@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"Go-lab/internal/utils/validate"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Message is a domain event waiting in the outbox table for the relay
+type Message struct {
+	Id          uint64          `db:"id" json:"id"` // increasing, consumers can dedupe and order on it
+	Aggregate   string          `db:"aggregate" json:"aggregate"`
+	AggregateId string          `db:"aggregate_id" json:"aggregate_id"`
+	Type        string          `db:"type" json:"type"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt   time.Time       `db:"created_at" json:"created_at"`
+	CreatedBy   int             `db:"created_by" json:"created_by"`
+	Attempts    uint            `db:"attempts" json:"-"`
+}
+
+// Write adds an event to the outbox in the caller's transaction, so it is committed or rolled back with the change
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func Write(ctx context.Context, tx *sqlx.Tx, aggregate string, aggregateId any, eventType string, payload any) error {
+	if err := validate.Get().Var(tx, "required"); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("outbox payload of %s: %w", eventType, err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox
+			(aggregate, aggregate_id, type, payload)
+		VALUES
+			(?, ?, ?, ?)`,
+		aggregate, fmt.Sprint(aggregateId), eventType, body,
+	); err != nil {
+		return fmt.Errorf("insert outbox %s: %w", eventType, err)
+	}
+
+	return nil
+}
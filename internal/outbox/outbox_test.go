@@ -0,0 +1,80 @@
+package outbox
+
+import (
+	"Go-lab/config"
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var messages = []Message{
+	{Id: 7, Aggregate: "player", AggregateId: "1", Type: "player.created", Payload: json.RawMessage(`{"id":1}`)},
+	{Id: 8, Aggregate: "player", AggregateId: "1", Type: "player.checked_in", Payload: json.RawMessage(`{"id":1}`)},
+}
+
+func TestFileSink(t *testing.T) {
+	req := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "outbox.ndjson")
+	sink := NewFileSink(path)
+	req.NoError(sink.Send(context.Background(), messages))
+	req.NoError(sink.Send(context.Background(), messages[1:]))
+
+	f, err := os.Open(path)
+	req.NoError(err)
+	defer f.Close()
+
+	var ids []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m Message
+		req.NoError(json.Unmarshal(scanner.Bytes(), &m))
+		ids = append(ids, m.Id)
+	}
+	req.Equal([]uint64{7, 8, 8}, ids)
+}
+
+func TestHTTPSink(t *testing.T) {
+	req := require.New(t)
+
+	var got []Message
+	var key string
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = r.Header.Get("Idempotency-Key")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, server.Client())
+	req.NoError(sink.Send(context.Background(), messages))
+	req.Len(got, 2)
+	req.Equal("player.checked_in", got[1].Type)
+	req.JSONEq(`{"id":1}`, string(got[0].Payload))
+	req.Equal("outbox-7-8", key)
+
+	status = http.StatusInternalServerError
+	req.Error(sink.Send(context.Background(), messages))
+}
+
+func TestNewSinks(t *testing.T) {
+	req := require.New(t)
+
+	sinks, err := NewSinks(config.OutboxConfig{Sinks: []string{"log", "file"}, FilePath: "x.ndjson"})
+	req.NoError(err)
+	req.Len(sinks, 2)
+
+	_, err = NewSinks(config.OutboxConfig{Sinks: []string{"http"}})
+	req.Error(err)
+
+	_, err = NewSinks(config.OutboxConfig{Sinks: []string{"kafka"}})
+	req.Error(err)
+}
@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Relay moves the outbox to the sinks. Messages are locked while the sinks get them and only marked delivered
+// once every sink took the batch, so a crash at any point means sending them again: at-least-once, in id order.
+type Relay struct {
+	db    *dbutils.DbUtils
+	sinks []Sink
+	cfg   config.OutboxConfig
+}
+
+func NewRelay(dbUtils *dbutils.DbUtils, cfg config.OutboxConfig, sinks ...Sink) *Relay {
+	if err := validate.Get().Var(dbUtils, "required"); err != nil {
+		panic(err)
+	}
+	return &Relay{
+		db:    dbUtils,
+		sinks: sinks,
+		cfg:   cfg,
+	}
+}
+
+// NewRelayService runs the relay on a schedule. A failing batch stops the run and is retried on the next tick,
+// the messages behind it wait so the order holds.
+func NewRelayService(r *Relay) utils.Service {
+	return utils.NewScheduledService("outbox-relay", r.cfg.Interval, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+		for ctx.Err() == nil {
+			n, err := r.Relay(ctx)
+			if err != nil {
+				return err
+			}
+			if n < int(r.cfg.BatchSize) {
+				break
+			}
+		}
+
+		pruned, err := r.Prune(ctx, time.Now().Add(-r.cfg.Keep))
+		if err != nil {
+			return err
+		}
+		if pruned > 0 {
+			slog.InfoContext(ctx, "outbox pruned", "removed", pruned)
+		}
+		return nil
+	})
+}
+
+// Relay hands one batch to the sinks and returns its size
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	var n int
+	var sendErr error
+
+	err := r.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		messages, err := repo.Lock(ctx, r.cfg.BatchSize)
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+		n = len(messages)
+
+		ids := make([]uint64, len(messages))
+		for i := range messages {
+			ids[i] = messages[i].Id
+		}
+
+		for _, sink := range r.sinks {
+			if err = sink.Send(ctx, messages); err != nil {
+				sendErr = fmt.Errorf("outbox sink %s: %w", sink.Name(), err)
+				return repo.MarkFailed(ctx, ids, sendErr)
+			}
+		}
+
+		return repo.MarkDelivered(ctx, ids)
+	})
+
+	return n, errors.Join(sendErr, err)
+}
+
+func (r *Relay) Prune(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	var pruned int64
+
+	err := r.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		pruned, err = repo.Prune(ctx, deliveredBefore)
+		return err
+	})
+
+	return pruned, err
+}
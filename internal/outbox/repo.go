@@ -0,0 +1,141 @@
+package outbox
+
+import (
+	"Go-lab/internal/utils/validate"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Repo struct {
+	tx *sqlx.Tx
+}
+
+func NewRepo(tx *sqlx.Tx) (*Repo, error) {
+	if err := validate.Get().Var(tx, "required"); err != nil {
+		return nil, fmt.Errorf("invalid tx: %w", err)
+	}
+
+	return &Repo{tx: tx}, nil
+}
+
+// Lock reads the oldest undelivered messages and keeps them locked until the transaction ends.
+// Another relay skips them rather than waiting.
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Lock(ctx context.Context, limit uint) ([]Message, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var messages []Message
+
+	if err := r.tx.SelectContext(ctx, &messages, `
+		SELECT
+			id,
+			aggregate,
+			aggregate_id,
+			type,
+			payload,
+			created_at,
+			created_by,
+			attempts
+		FROM
+			outbox
+		WHERE
+			delivered_at IS NULL
+		ORDER BY
+			id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`,
+		limit,
+	); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) MarkDelivered(ctx context.Context, ids []uint64) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	stmt, args, err := sqlx.In(`
+		UPDATE
+			outbox
+		SET
+			delivered_at = CURRENT_TIMESTAMP(6),
+			attempts = attempts + 1,
+			last_error = NULL
+		WHERE
+			id IN (?)`,
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err = r.tx.ExecContext(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("mark outbox delivered: %w", err)
+	}
+
+	return nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) MarkFailed(ctx context.Context, ids []uint64, cause error) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	msg := cause.Error()
+	if len(msg) > 1000 {
+		msg = msg[:1000]
+	}
+
+	stmt, args, err := sqlx.In(`
+		UPDATE
+			outbox
+		SET
+			attempts = attempts + 1,
+			last_error = ?
+		WHERE
+			id IN (?)`,
+		msg, ids,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err = r.tx.ExecContext(ctx, stmt, args...); err != nil {
+		return fmt.Errorf("mark outbox failed: %w", err)
+	}
+
+	return nil
+}
+
+// Prune removes delivered messages older than the given time
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Prune(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		DELETE FROM
+			outbox
+		WHERE
+			delivered_at < ?`,
+		deliveredBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("prune outbox: %w", err)
+	}
+
+	return res.RowsAffected()
+}
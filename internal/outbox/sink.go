@@ -0,0 +1,148 @@
+package outbox
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils/httpconst"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-http-utils/headers"
+)
+
+// Sink receives the messages of a batch in order. An error fails the whole batch, it is handed to every
+// sink again on the next run, so sinks must cope with duplicates.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, messages []Message) error
+}
+
+// NewSinks builds the sinks named in the config
+func NewSinks(cfg config.OutboxConfig) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "":
+		case "log":
+			sinks = append(sinks, LogSink{})
+		case "http":
+			if cfg.HTTPUrl == "" {
+				return nil, fmt.Errorf("outbox http sink needs a url")
+			}
+			sinks = append(sinks, NewHTTPSink(cfg.HTTPUrl, &http.Client{Timeout: 10 * time.Second}))
+		case "file":
+			if cfg.FilePath == "" {
+				return nil, fmt.Errorf("outbox file sink needs a path")
+			}
+			sinks = append(sinks, NewFileSink(cfg.FilePath))
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// LogSink logs every message, for development
+type LogSink struct{}
+
+func (LogSink) Name() string {
+	return "log"
+}
+
+func (LogSink) Send(_ context.Context, messages []Message) error {
+	for _, m := range messages {
+		slog.Info("outbox", "id", m.Id, "type", m.Type, "aggregate", m.Aggregate, "aggregate_id", m.AggregateId)
+	}
+	return nil
+}
+
+// HTTPSink POSTs each batch as a JSON array. The Idempotency-Key names the batch, a retried batch may be
+// cut differently though, so consumers should dedupe on the message id.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSink{url: url, client: client}
+}
+
+func (s *HTTPSink) Name() string {
+	return "http"
+}
+
+func (s *HTTPSink) Send(ctx context.Context, messages []Message) error {
+	body, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(headers.ContentType, httpconst.ApplicationJSON)
+	req.Header.Set("Idempotency-Key", "outbox-"+strconv.FormatUint(messages[0].Id, 10)+"-"+
+		strconv.FormatUint(messages[len(messages)-1].Id, 10))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("outbox consumer answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FileSink appends the messages to a file, one JSON object per line, synced before the batch counts as delivered
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Send(_ context.Context, messages []Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, m := range messages {
+		if err = encoder.Encode(m); err != nil {
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
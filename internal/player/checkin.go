@@ -0,0 +1,91 @@
+package player
+
+import (
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/query"
+	"Go-lab/internal/utils/validate"
+	"time"
+)
+
+// Checkin is one visit of a player, player_entity.last_checkin only keeps the latest
+type Checkin struct {
+	Id          *uint      `db:"id"`
+	PlayerId    uint       `db:"player_id"`
+	CheckedInAt *time.Time `db:"checked_in_at"`
+	CheckedInBy *uint      `db:"checked_in_by"`
+	Source      *string    `db:"source" validate:"omitnil,notblank,max=50"`
+	Note        *string    `db:"note" validate:"omitnil,notblank,max=255"`
+}
+
+func (c *Checkin) Validate() error {
+	return validate.Get().Struct(c)
+}
+
+func (c *Checkin) String() string {
+	return utils.ToString(*c)
+}
+
+// CheckinDto is the optional body of PUT /player/checkin/{id}
+type CheckinDto struct {
+	Source *string `json:"source"`
+	Note   *string `json:"note"`
+}
+
+// Validate holds the body to the rules of Checkin; no body at all is fine
+func (c *CheckinDto) Validate() error {
+	if c == nil {
+		return nil
+	}
+	return (&Checkin{Source: c.Source, Note: c.Note}).Validate()
+}
+
+type CheckinHistoryDto struct {
+	Id          *uint      `json:"id"`
+	PlayerId    uint       `json:"player_id"`
+	CheckedInAt *time.Time `json:"checked_in_at"`
+	CheckedInBy *uint      `json:"checked_in_by"`
+	Source      *string    `json:"source"`
+	Note        *string    `json:"note"`
+}
+
+func ToCheckinDTOs(checkins []Checkin) ([]CheckinHistoryDto, error) {
+	res := make([]CheckinHistoryDto, len(checkins))
+	for i := range checkins {
+		c := &checkins[i]
+		res[i] = CheckinHistoryDto{
+			Id:          c.Id,
+			PlayerId:    c.PlayerId,
+			CheckedInAt: c.CheckedInAt,
+			CheckedInBy: c.CheckedInBy,
+			Source:      c.Source,
+			Note:        c.Note,
+		}
+	}
+	return res, nil
+}
+
+// checkinSchema whitelists what GET /player/{id}/checkins can filter and sort on.
+var checkinSchema = query.Schema[Checkin]{
+	Fields: map[string]query.Field[Checkin]{
+		"checked_in_at": {
+			Column:   "checked_in_at",
+			Kind:     query.Time,
+			Ops:      []query.Op{query.Lt, query.Lte, query.Gt, query.Gte},
+			Sortable: true,
+			Key:      func(c Checkin) any { return c.CheckedInAt },
+		},
+		"source": {
+			Column: "source",
+			Kind:   query.String,
+			Ops:    []query.Op{query.Eq, query.In},
+		},
+	},
+	IdColumn: "id",
+	Id: func(c Checkin) uint {
+		if c.Id == nil {
+			return 0
+		}
+		return *c.Id
+	},
+	DefaultSort: []query.Sort{{Field: "checked_in_at", Desc: true}},
+}
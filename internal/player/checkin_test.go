@@ -0,0 +1,228 @@
+package player
+
+import (
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/query"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckinSchemaFilters(t *testing.T) {
+	req := require.New(t)
+
+	q, err := checkinSchema.Parse(url.Values{
+		"checked_in_at[after]": {"2026-01-01T00:00:00Z"},
+		"checked_in_at[lte]":   {"2026-02-01T00:00:00Z"},
+		"source[in]":           {"app,desk"},
+	}, false)
+	req.NoError(err)
+	req.Equal(checkinSchema.DefaultSort, q.Sort)
+
+	where, args := checkinWhereOf(7, q)
+	req.Contains(where, "player_id = ? AND ")
+	req.Contains(where, "checked_in_at > ?")
+	req.Contains(where, "checked_in_at <= ?")
+	req.Contains(where, "source IN (?, ?)")
+	req.Len(args, 5)
+	req.Equal(uint(7), args[0])
+
+	where, args = checkinWhereOf(7, query.Query{})
+	req.Equal("player_id = ?", where)
+	req.Equal([]any{uint(7)}, args)
+
+	for _, values := range []url.Values{
+		{"checked_in_at[after]": {"yesterday"}},
+		{"checked_in_at[eq]": {"2026-01-01T00:00:00Z"}},
+		{"source[lt]": {"app"}},
+		{"note": {"x"}},
+		{"sort": {"source"}},
+	} {
+		_, err := checkinSchema.Parse(values, false)
+		req.Error(err, "%v", values)
+	}
+}
+
+func TestCheckinSchemaKeyset(t *testing.T) {
+	req := require.New(t)
+
+	q, err := checkinSchema.Parse(url.Values{}, false)
+	req.NoError(err)
+	req.Equal("checked_in_at DESC, id ASC", checkinSchema.OrderBy(q, false))
+	req.Equal("checked_in_at ASC, id DESC", checkinSchema.OrderBy(q, true))
+
+	id := uint(9)
+	at := time.Date(2026, 3, 4, 5, 6, 7, 8000, time.UTC)
+	cursor := checkinSchema.Cursor(q, Checkin{Id: &id, CheckedInAt: &at})
+	req.Equal(id, cursor.Id)
+
+	// the next page are the older visits, ties broken by id
+	cursor.Dir = paging.Next
+	req.NoError(checkinSchema.Check(q, &cursor))
+	where, args, err := checkinSchema.Keyset(q, &cursor)
+	req.NoError(err)
+	req.Equal("((checked_in_at < ?) OR (checked_in_at = ? AND id > ?))", where)
+	req.Len(args, 3)
+	req.True(at.Equal(args[0].(time.Time)))
+	req.Equal(id, args[2])
+
+	// walking back are the newer ones
+	cursor.Dir = paging.Prev
+	where, _, err = checkinSchema.Keyset(q, &cursor)
+	req.NoError(err)
+	req.Equal("((checked_in_at > ?) OR (checked_in_at = ? AND id < ?))", where)
+
+	// a cursor is only good for the sort it was made for
+	_, _, err = checkinSchema.Keyset(q, &paging.Cursor{Keys: []string{"", ""}, Id: id})
+	req.True(errors.Is(err, query.ErrInvalid))
+}
+
+func TestCheckinDtoValidate(t *testing.T) {
+	req := require.New(t)
+
+	// older clients send no body
+	var dto *CheckinDto
+	req.NoError(dto.Validate())
+	req.NoError((&CheckinDto{}).Validate())
+
+	source, note := "desk", "came by car"
+	req.NoError((&CheckinDto{Source: &source, Note: &note}).Validate())
+
+	blank, long := " ", strings.Repeat("x", 256)
+	req.Error((&CheckinDto{Source: &blank}).Validate())
+	req.Error((&CheckinDto{Note: &long}).Validate())
+
+	// rejected before a transaction is opened
+	_, err := (&Service{}).Checkin(context.Background(), 1, etag.Versions{Any: true}, &CheckinDto{Source: &blank})
+	req.Error(err)
+}
+
+func TestRepoCheckin(t *testing.T) {
+	req := require.New(t)
+
+	updatedAt := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := &checkinConn{id: 1, updatedAt: updatedAt, now: time.Date(2026, 5, 6, 7, 8, 9, 0, time.UTC)}
+	db := sqlx.NewDb(sql.OpenDB(fake), "mysql")
+	defer db.Close()
+
+	tx, err := db.Beginx()
+	req.NoError(err)
+	defer tx.Rollback()
+	repo, err := NewRepo(tx)
+	req.NoError(err)
+
+	source := "desk"
+	player, err := repo.Checkin(context.Background(), 1, etag.Exact(&updatedAt), &CheckinDto{Source: &source})
+	req.NoError(err)
+
+	// the history row is a copy of last_checkin, not a second clock reading
+	req.Len(fake.history, 1)
+	req.True(fake.now.Equal(*player.LastCheckin))
+	req.True(player.LastCheckin.Equal(fake.history[0].CheckedInAt))
+	req.Equal("desk", fake.history[0].Source)
+	req.Equal(1, fake.events)
+
+	// a stale version neither moves last_checkin nor adds a visit
+	fake.now = fake.now.Add(time.Hour)
+	stale := updatedAt.Add(-time.Second)
+	_, err = repo.Checkin(context.Background(), 1, etag.Exact(&stale), nil)
+	req.True(errors.Is(err, sql.ErrNoRows))
+	req.Len(fake.history, 1)
+	req.Equal(1, fake.events)
+
+	// any version, no body
+	_, err = repo.Checkin(context.Background(), 1, etag.Versions{Any: true}, nil)
+	req.NoError(err)
+	req.Len(fake.history, 2)
+	req.True(fake.now.Equal(fake.history[1].CheckedInAt))
+	req.Nil(fake.history[1].Source)
+}
+
+// checkinConn is a database holding one player, just enough of it for Repo.Checkin
+type checkinConn struct {
+	id          int64
+	updatedAt   time.Time
+	lastCheckin any
+	now         time.Time
+	history     []struct {
+		CheckedInAt time.Time
+		Source      any
+	}
+	events int
+}
+
+func (c *checkinConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *checkinConn) Driver() driver.Driver                        { return nil }
+func (c *checkinConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *checkinConn) Close() error                                 { return nil }
+func (c *checkinConn) Begin() (driver.Tx, error)                    { return c, nil }
+func (c *checkinConn) Commit() error                                { return nil }
+func (c *checkinConn) Rollback() error                              { return nil }
+
+func (c *checkinConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	switch {
+	case strings.HasPrefix(query, "UPDATE player_entity SET last_checkin = CURRENT_TIMESTAMP WHERE id = ? AND "):
+		match := strings.HasSuffix(query, "TRUE")
+		for _, arg := range args[1:] {
+			if v, ok := arg.Value.(time.Time); ok && v.Equal(c.updatedAt) {
+				match = true
+			}
+		}
+		if args[0].Value != c.id || !match {
+			return driver.RowsAffected(0), nil
+		}
+		c.lastCheckin = c.now
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "INSERT INTO player_checkin (player_id, checked_in_at, source, note) SELECT id, last_checkin, ?, ? FROM player_entity WHERE id = ?"):
+		c.history = append(c.history, struct {
+			CheckedInAt time.Time
+			Source      any
+		}{c.lastCheckin.(time.Time), args[0].Value})
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(query, "INSERT INTO outbox"):
+		c.events++
+		return driver.RowsAffected(1), nil
+	}
+	return nil, fmt.Errorf("unexpected statement %q", query)
+}
+
+func (c *checkinConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(query, "FROM\n\t\t\tplayer_entity") {
+		return nil, fmt.Errorf("unexpected query %q", query)
+	}
+	return &checkinRows{values: []driver.Value{
+		c.id, "r1", "Ann", nil, c.lastCheckin, c.updatedAt, int64(0), c.updatedAt, int64(0),
+	}}, nil
+}
+
+type checkinRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *checkinRows) Columns() []string {
+	return []string{"id", "resource_id", "name", "description", "last_checkin", "created_at", "created_by", "updated_at", "updated_by"}
+}
+
+func (r *checkinRows) Close() error { return nil }
+
+func (r *checkinRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
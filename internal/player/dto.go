@@ -20,6 +20,7 @@ type DTO struct {
 	CreatedBy   *uint      `json:"created_by"`
 	UpdatedAt   *time.Time `json:"updated_at"`
 	UpdatedBy   *uint      `json:"updated_by"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 func (d *DTO) String() string {
@@ -0,0 +1,90 @@
+package player
+
+import (
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/sse"
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	eventBuffer     = 1000 // events kept for clients resuming with a Last-Event-ID
+	eventMaxClients = 500
+	eventHeartbeat  = 15 * time.Second // keeps proxies from closing idle streams
+)
+
+// EventType names a change of a player, in the outbox and for the listeners
+type EventType string
+
+const (
+	EventCreated   EventType = "player.created"
+	EventUpdated   EventType = "player.updated" // restores included
+	EventDeleted   EventType = "player.deleted"
+	EventCheckedIn EventType = "player.checked_in"
+)
+
+// Event is published once the transaction that made the change has committed
+type Event struct {
+	Type   EventType
+	At     time.Time
+	Player DTO
+}
+
+// Listener runs on the goroutine of the change, keep it short. The context outlives the request.
+type Listener func(ctx context.Context, e Event)
+
+// Subscribe adds a listener for every player event
+func (s *Service) Subscribe(l Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, l)
+}
+
+// publish goes after the WithTransaction of the change. In a transaction joined from the context, see
+// dbutils.ContextWithTx, the events wait for the owner to commit.
+func (s *Service) publish(ctx context.Context, t EventType, players ...*Player) {
+	dbutils.AfterCommit(ctx, func() {
+		s.notify(ctx, t, players)
+	})
+}
+
+func (s *Service) notify(ctx context.Context, t EventType, players []*Player) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+
+	if len(listeners) == 0 {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	at := time.Now()
+
+	for _, p := range players {
+		dto, err := ToDTO(p)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot publish player event", "type", t, "error", err)
+			continue
+		}
+
+		e := Event{Type: t, At: at, Player: *dto}
+		for _, l := range listeners {
+			l(ctx, e)
+		}
+	}
+}
+
+// NewEventStream feeds the player events to a Server-Sent Events broker, it serves GET /player/events
+func NewEventStream(service *Service) *sse.Broker {
+	broker := sse.NewBroker(eventBuffer, eventMaxClients, eventHeartbeat)
+
+	service.Subscribe(func(_ context.Context, e Event) {
+		if err := broker.Publish(string(e.Type), e.Player); err != nil {
+			slog.Error("cannot stream player event", "type", e.Type, "error", err)
+		}
+	})
+
+	return broker
+}
@@ -0,0 +1,206 @@
+package player
+
+import (
+	"Go-lab/internal/security"
+	"Go-lab/internal/utils/httpconst"
+	"Go-lab/internal/utils/problem"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	exportFlushEvery = 1_000
+)
+
+var exportHeader = []string{"id", "resource_id", "name", "description", "last_checkin", "created_at", "created_by", "updated_at", "updated_by"}
+
+// Export streams every matching player as CSV or NDJSON. It takes the same filters as List, but no paging.
+// The route is mounted outside the service timeout; the export runs for as long as the client keeps reading.
+func (h Handler) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatCSV
+	}
+	if format != formatCSV && format != formatNDJSON {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	values := r.URL.Query()
+	values.Del("format")
+
+	admin, err := h.authorizer.Has(r.Context(), security.ScopeAdmin)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	q, err := querySchema.Parse(values, admin)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeExport(w, r, format, func(fn func(*Player) error) error {
+		return h.service.Export(r.Context(), q, fn)
+	})
+}
+
+// writeExport writes the players stream hands it in the format, flushing every exportFlushEvery rows
+func writeExport(w http.ResponseWriter, r *http.Request, format string, stream func(fn func(*Player) error) error) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println(err)
+	}
+
+	out := newExportWriter(w, format)
+	count := 0
+
+	err := stream(func(p *Player) error {
+		if err := out.write(p); err != nil {
+			return err
+		}
+		if count++; count%exportFlushEvery == 0 {
+			return out.flush(rc)
+		}
+		return nil
+	})
+	if err != nil {
+		if !out.started {
+			problem.Write(w, r, err)
+			return
+		}
+		// too late for a status code, the truncated body is all the client gets
+		log.Printf("player export aborted after %d rows: %v", count, err)
+		return
+	}
+
+	if err := out.finish(rc); err != nil {
+		log.Printf("player export: %v", err)
+	}
+}
+
+// exportWriter only commits the response once there is something to write, so early errors still get a 500
+type exportWriter struct {
+	w       http.ResponseWriter
+	format  string
+	csv     *csv.Writer
+	json    *json.Encoder
+	started bool
+}
+
+func newExportWriter(w http.ResponseWriter, format string) *exportWriter {
+	return &exportWriter{w: w, format: format}
+}
+
+func (e *exportWriter) start() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+
+	contentType := httpconst.TextCSV
+	if e.format == formatNDJSON {
+		contentType = httpconst.ApplicationNDJSON
+	}
+	filename := fmt.Sprintf("players-%s.%s", time.Now().UTC().Format("20060102T150405Z"), e.format)
+
+	e.w.Header().Set(headers.ContentType, contentType)
+	e.w.Header().Set(headers.ContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	e.w.WriteHeader(http.StatusOK)
+
+	if e.format == formatNDJSON {
+		e.json = json.NewEncoder(e.w)
+		return nil
+	}
+	e.csv = csv.NewWriter(e.w)
+	return e.csv.Write(exportHeader)
+}
+
+func (e *exportWriter) write(p *Player) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+
+	if e.json != nil {
+		dto, err := ToDTO(p)
+		if err != nil {
+			return err
+		}
+		return e.json.Encode(dto)
+	}
+
+	return e.csv.Write([]string{
+		formatUint(p.Id),
+		csvCell(p.ResourceId),
+		csvCell(p.Name),
+		csvCell(formatString(p.Description)),
+		formatTime(p.LastCheckin),
+		formatTime(p.CreatedAt),
+		formatUint(p.CreatedBy),
+		formatTime(p.UpdatedAt),
+		formatUint(p.UpdatedBy),
+	})
+}
+
+func (e *exportWriter) flush(rc *http.ResponseController) error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// finish writes the CSV header even for an empty export
+func (e *exportWriter) finish(rc *http.ResponseController) error {
+	if err := e.start(); err != nil {
+		return err
+	}
+	return e.flush(rc)
+}
+
+// csvCell keeps spreadsheets from running user text as a formula by prefixing cells that start like one with a '
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func formatUint(v *uint) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*v), 10)
+}
+
+func formatString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+func formatTime(v *time.Time) string {
+	if v == nil {
+		return ""
+	}
+	return v.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,114 @@
+package player
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func exportPlayers() []Player {
+	id1, id2 := uint(1), uint(2)
+	formula := "=HYPERLINK(\"http://evil\")"
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []Player{
+		{Id: &id1, ResourceId: "r1", Name: "Ann", LastCheckin: &created},
+		{Id: &id2, ResourceId: "r2", Name: "@SUM(A1)", Description: &formula},
+	}
+}
+
+func runExport(format string, players []Player, err error) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	writeExport(w, httptest.NewRequest(http.MethodGet, "/player/export", nil), format, func(fn func(*Player) error) error {
+		for i := range players {
+			if err := fn(&players[i]); err != nil {
+				return err
+			}
+		}
+		return err
+	})
+	return w
+}
+
+func TestExportCSV(t *testing.T) {
+	req := require.New(t)
+
+	w := runExport(formatCSV, exportPlayers(), nil)
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(httpconst.TextCSV, w.Header().Get(headers.ContentType))
+	req.Contains(w.Header().Get(headers.ContentDisposition), `.csv"`)
+
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	req.NoError(err)
+	req.Len(rows, 3)
+	req.Equal(exportHeader, rows[0])
+	req.Equal([]string{"1", "r1", "Ann", "", "2026-01-02T03:04:05Z", "", "", "", ""}, rows[1])
+
+	// user text that starts like a formula is not run by a spreadsheet
+	req.Equal("'@SUM(A1)", rows[2][2])
+	req.Equal(`'=HYPERLINK("http://evil")`, rows[2][3])
+}
+
+func TestExportNDJSON(t *testing.T) {
+	req := require.New(t)
+
+	w := runExport(formatNDJSON, exportPlayers(), nil)
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(httpconst.ApplicationNDJSON, w.Header().Get(headers.ContentType))
+
+	var names []string
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var dto DTO
+		req.NoError(json.Unmarshal(scanner.Bytes(), &dto))
+		names = append(names, dto.Name)
+	}
+	// only CSV cells are escaped
+	req.Equal([]string{"Ann", "@SUM(A1)"}, names)
+}
+
+func TestExportEmpty(t *testing.T) {
+	req := require.New(t)
+
+	w := runExport(formatCSV, nil, nil)
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(strings.Join(exportHeader, ",")+"\n", w.Body.String())
+
+	w = runExport(formatNDJSON, nil, nil)
+	req.Equal(http.StatusOK, w.Code)
+	req.Empty(w.Body.String())
+}
+
+func TestExportError(t *testing.T) {
+	req := require.New(t)
+
+	// nothing written yet, the client still gets a status
+	w := runExport(formatCSV, nil, errors.New("connection lost"))
+	req.Equal(http.StatusInternalServerError, w.Code)
+	req.Equal(httpconst.ApplicationProblemJSON, w.Header().Get(headers.ContentType))
+
+	// after the first row the body is just cut short
+	w = runExport(formatCSV, exportPlayers()[:1], errors.New("connection lost"))
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(httpconst.TextCSV, w.Header().Get(headers.ContentType))
+}
+
+func TestCSVCell(t *testing.T) {
+	req := require.New(t)
+
+	for _, v := range []string{"=1+1", "+1", "-1", "@A1", "\t=1", "\r=1"} {
+		req.Equal("'"+v, csvCell(v))
+	}
+	req.Equal("", csvCell(""))
+	req.Equal("Ann", csvCell("Ann"))
+	req.Equal("a=b", csvCell("a=b"))
+}
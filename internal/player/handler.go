@@ -3,65 +3,126 @@ package player
 import (
 	"Go-lab/config"
 	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/security"
 	"Go-lab/internal/utils/httpconst"
 	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/patch"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/query"
 	"Go-lab/internal/utils/validate"
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-http-utils/headers"
 )
 
+const (
+	headerLink        = "Link"
+	headerTotalCount  = "X-Total-Count"
+	headerAcceptPatch = "Accept-Patch"
+
+	maxPatchSize  = 1 << 20  // 1 MB
+	maxImportSize = 32 << 20 // 32 MB
+)
+
 type Handler struct {
-	service *Service
-	cfg     config.AppConfig
+	service    *Service
+	authorizer *security.Authorizer
+	cfg        config.AppConfig
+	retention  config.RetentionConfig
 }
 
-func NewHandler(service *Service, cfg config.AppConfig) *Handler {
+func NewHandler(service *Service, authorizer *security.Authorizer, cfg config.AppConfig, retention config.RetentionConfig) *Handler {
 	if err := validate.Get().Var(service, "required"); err != nil {
 		panic(err)
 	}
+	if err := validate.Get().Var(authorizer, "required"); err != nil {
+		panic(err)
+	}
 	return &Handler{
-		service: service,
-		cfg:     cfg,
+		service:    service,
+		authorizer: authorizer,
+		cfg:        cfg,
+		retention:  retention,
 	}
 }
 
+// List filters, sorts and pages through the players. Offset clients (page/limit) get the bare array as before,
+// keyset clients (cursor, empty for the first page) get a paging.Page envelope.
 func (h Handler) List(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
 	defer cancel()
 
-	page, err := paging.ParsePage(chi.URLParam(r, "page"))
+	p, err := paging.Parse(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	admin, err := h.authorizer.Has(r.Context(), security.ScopeAdmin)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	q, err := querySchema.Parse(r.URL.Query(), admin)
+	if err == nil {
+		err = querySchema.Check(q, p.Cursor)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, err)
 		return
 	}
-	limit, err := paging.ParseLimit(chi.URLParam(r, "limit"))
+
+	// pollers revalidate every time, an unchanged collection costs one aggregate query and a 304.
+	// No Last-Modified: a removed row lowers the count but not the newest timestamp.
+	version, err := h.service.Version(ctx, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, err)
+		return
+	}
+	w.Header().Set(headers.CacheControl, "private, no-cache")
+	collectionETag := etag.MakeCollectionETag(version.Count, version.LastModified(), r.URL.Query())
+	if etag.Check(w, r, etag.Validators{ETag: collectionETag}) {
 		return
 	}
 
-	players, err := h.service.FindAll(ctx, paging.NewPaging(page, limit))
+	players, err := h.service.FindAll(ctx, p, q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, err)
 		return
 	}
 
-	dtos, err := ToDTOs(players)
+	page, err := paging.Map(*players, ToDTOs)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headerLink, paging.Links(*r.URL, p, page))
+
+	if !p.IsKeyset() {
+		if page.Total != nil {
+			w.Header().Set(headerTotalCount, strconv.FormatUint(uint64(*page.Total), 10))
+		}
+		writeJSON(w, http.StatusOK, page.Items)
 		return
 	}
 
-	writeJSON(w, http.StatusOK, dtos)
+	writeJSON(w, http.StatusOK, page)
 }
 
 func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
@@ -76,10 +137,9 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 	player, err := h.service.FindById(ctx, uint(id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusNotFound, "player not found")
-			return
+			err = ErrNotFound
 		}
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -89,7 +149,7 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 
 	dto, err := ToDTO(player)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -99,7 +159,7 @@ func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
 func (h Handler) GetResource(w http.ResponseWriter, r *http.Request) {
 	resourceID := chi.URLParam(r, "resource_id")
 	if resourceID == "" {
-		http.Error(w, "invalid resource id", http.StatusBadRequest)
+		problem.WriteStatus(w, r, http.StatusBadRequest, "invalid resource id")
 		return
 	}
 
@@ -109,10 +169,9 @@ func (h Handler) GetResource(w http.ResponseWriter, r *http.Request) {
 	player, err := h.service.FindByResourceId(ctx, resourceID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusNotFound, "player not found")
-			return
+			err = ErrNotFound
 		}
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -122,7 +181,7 @@ func (h Handler) GetResource(w http.ResponseWriter, r *http.Request) {
 
 	dto, err := ToDTO(player)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, dto)
@@ -137,36 +196,43 @@ func (h Handler) Checkin(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
 	defer cancel()
 
-	header := r.Header.Get("If-Match")
-	version, err := etag.ParseETag(header)
+	version, err := etag.Version(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
-	player, err := h.service.Checkin(ctx, uint(id), version)
+	// the body is optional, older clients send none
+	var checkin *CheckinDto
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&checkin); err != nil && !errors.Is(err, io.EOF) {
+			problem.WriteStatus(w, r, http.StatusBadRequest, "bad JSON format")
+			return
+		}
+	}
+
+	player, err := h.service.Checkin(ctx, uint(id), version, checkin)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusConflict, "player already modified by another request, please refresh and retry.")
-			return
+			err = ErrConflict
 		}
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
 	dto, err := ToDTO(player)
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, dto)
 }
 
 type UpdateDto struct {
-	Id          *uint      `db:"id"`
-	Name        string     `db:"name"`
-	Description *string    `db:"description"`
-	UpdatedAt   *time.Time `db:"updated_at"`
+	Id          *uint         `db:"id"`
+	Name        string        `db:"name"`
+	Description *string       `db:"description"`
+	Version     etag.Versions `db:"-" json:"-"`
 }
 
 func (h Handler) Update(w http.ResponseWriter, r *http.Request) {
@@ -178,38 +244,107 @@ func (h Handler) Update(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
 	defer cancel()
 
-	header := r.Header.Get(headers.IfMatch)
-
-	version, err := etag.ParseETag(header)
+	version, err := etag.Version(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
 	var _dto *UpdateDto
 	// Parse JSON body
 	if err := json.NewDecoder(r.Body).Decode(&_dto); err != nil {
-		http.Error(w, "Bad JSON format", http.StatusBadRequest)
+		problem.WriteStatus(w, r, http.StatusBadRequest, "bad JSON format")
 		return
 	}
 
 	_id := uint(id)
 	_dto.Id = &_id
-	_dto.UpdatedAt = version
+	_dto.Version = version
 
 	err = h.service.Update(ctx, _dto)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusConflict, "player already modified by another request, please refresh and retry.")
-			return
+			err = ErrConflict
 		}
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
 	writeJSON(w, http.StatusNoContent, nil)
 }
 
+// PatchDto is the part of a player a PATCH may touch; anything else in the patched document is rejected.
+type PatchDto struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+}
+
+// Patch applies a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902) to the editable fields of a player.
+func (h Handler) Patch(w http.ResponseWriter, r *http.Request) {
+	id, err := h.getPathParamId(w, r)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	version, err := etag.Version(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get(headers.ContentType))
+	if err != nil || (mediaType != httpconst.ApplicationMergePatchJSON && mediaType != httpconst.ApplicationJSONPatchJSON) {
+		w.Header().Set(headerAcceptPatch, patch.Accept)
+		problem.Write(w, r, patch.ErrUnsupported)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPatchSize))
+	if err != nil {
+		problem.Write(w, r, patch.ErrInvalid)
+		return
+	}
+
+	player, err := h.service.Patch(ctx, uint(id), version, func(p *Player) error {
+		doc, err := json.Marshal(PatchDto{Name: p.Name, Description: p.Description})
+		if err != nil {
+			return err
+		}
+
+		patched, err := patch.Apply(mediaType, doc, body)
+		if err != nil {
+			return err
+		}
+
+		var dto PatchDto
+		decoder := json.NewDecoder(bytes.NewReader(patched))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&dto); err != nil {
+			return fmt.Errorf("%w: %v", patch.ErrInvalid, err)
+		}
+
+		p.Name = dto.Name
+		p.Description = dto.Description
+		return nil
+	})
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dto, err := ToDTO(player)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headers.ETag, etag.MakeWeakETag(player.UpdatedAt))
+	writeJSON(w, http.StatusOK, dto)
+}
+
 func (h Handler) Create(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
 	defer cancel()
@@ -218,19 +353,19 @@ func (h Handler) Create(w http.ResponseWriter, r *http.Request) {
 
 	// Parse JSON body
 	if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
-		http.Error(w, "Bad JSON format", http.StatusBadRequest)
+		problem.WriteStatus(w, r, http.StatusBadRequest, "bad JSON format")
 		return
 	}
 
 	player, err := ToEntity(*dto)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
 
 	id, err := h.service.Create(ctx, player)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusNotFound, "player not found")
-			return
-		}
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
@@ -239,6 +374,79 @@ func (h Handler) Create(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, id)
 }
 
+// Import bulk creates players from a CSV upload, either the raw text/csv body or the "file" part of a
+// multipart form. ?dry_run=true only validates. The response is a per-row report.
+// The route is mounted outside the service timeout, thousands of rows get the import timeout instead.
+func (h Handler) Import(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.ImportTimeout)
+	defer cancel()
+
+	// the server's read and write timeouts are meant for ordinary requests
+	deadline := time.Now().Add(h.cfg.ImportTimeout)
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println(err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Println(err)
+	}
+
+	dryRun := false
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			problem.WriteStatus(w, r, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	body, err := csvBody(r)
+	if err != nil {
+		problem.Write(w, r, problem.Wrap(problem.ErrBadRequest, err))
+		return
+	}
+
+	report, err := h.service.Import(ctx, body, dryRun)
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, report)
+	case errors.Is(err, ErrImportFormat) && report != nil:
+		writeJSON(w, http.StatusBadRequest, report)
+	case report != nil:
+		slog.ErrorContext(r.Context(), "player import failed", "error", err, "request_id", middleware.GetReqID(r.Context()))
+		writeJSON(w, http.StatusInternalServerError, report)
+	default:
+		problem.Write(w, r, err)
+	}
+}
+
+func csvBody(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headers.ContentType))
+
+	switch mediaType {
+	case "multipart/form-data":
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				return nil, fmt.Errorf("missing 'file' part")
+			}
+			if part.FormName() == "file" {
+				return part, nil
+			}
+		}
+	case httpconst.TextCSV, "":
+		return r.Body, nil
+	default:
+		return nil, fmt.Errorf("expected %s or multipart/form-data", httpconst.TextCSV)
+	}
+}
+
 func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	id, err := h.getPathParamId(w, r)
 	if err != nil {
@@ -248,31 +456,175 @@ func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
 	defer cancel()
 
-	header := r.Header.Get(headers.IfMatch)
-
-	version, err := etag.ParseETag(header)
+	version, err := etag.Version(r)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
 	err = h.service.Delete(ctx, uint(id), version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			writeJSON(w, http.StatusConflict, "player already modified by another request, please refresh and retry.")
-			return
+			err = ErrConflict
 		}
-		writeJSON(w, http.StatusInternalServerError, err.Error())
+		problem.Write(w, r, err)
 		return
 	}
 
 	writeJSON(w, http.StatusNoContent, nil)
 }
 
+// Checkins pages through the check-in history of a player, newest first unless sorted otherwise.
+// Filters: checked_in_at[after|before|gte|lte], source, source[in].
+func (h Handler) Checkins(w http.ResponseWriter, r *http.Request) {
+	id, err := h.getPathParamId(w, r)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	p, err := paging.Parse(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	q, err := checkinSchema.Parse(r.URL.Query(), false)
+	if err == nil {
+		err = checkinSchema.Check(q, p.Cursor)
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	checkins, err := h.service.FindCheckins(ctx, uint(id), p, q)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	page, err := paging.Map(*checkins, ToCheckinDTOs)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headerLink, paging.Links(*r.URL, p, page))
+	writeJSON(w, http.StatusOK, page)
+}
+
+// Trash lists the soft deleted players, paged like List
+func (h Handler) Trash(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	p, err := paging.Parse(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	q := query.Query{Sort: []query.Sort{{Field: "name"}}, OnlyDeleted: true}
+	if err = querySchema.Check(q, p.Cursor); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	players, err := h.service.FindAll(ctx, p, q)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	page, err := paging.Map(*players, ToDTOs)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headerLink, paging.Links(*r.URL, p, page))
+	writeJSON(w, http.StatusOK, page)
+}
+
+// Restore takes a player out of the trash. The If-Match is the ETag of the deleted player.
+func (h Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := h.getPathParamId(w, r)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	version, err := etag.Version(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	player, err := h.service.Restore(ctx, uint(id), version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNotInTrash
+		}
+		problem.Write(w, r, err)
+		return
+	}
+
+	dto, err := ToDTO(player)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headers.ETag, etag.MakeWeakETag(player.UpdatedAt))
+	writeJSON(w, http.StatusOK, dto)
+}
+
+// PurgeTrash is the admin-only, on demand version of the retention sweep; the route requires the admin scope.
+// ?older_than_days=N overrides the configured retention period, ?mode=delete removes instead of anonymising.
+func (h Handler) PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	after := h.retention.After
+	if s := r.URL.Query().Get("older_than_days"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 0 {
+			problem.WriteStatus(w, r, http.StatusBadRequest, "invalid older_than_days")
+			return
+		}
+		after = time.Duration(days) * 24 * time.Hour
+	}
+
+	anonymise := h.retention.Anonymise
+	switch r.URL.Query().Get("mode") {
+	case "":
+	case "anonymise":
+		anonymise = true
+	case "delete":
+		anonymise = false
+	default:
+		problem.WriteStatus(w, r, http.StatusBadRequest, "mode must be anonymise or delete")
+		return
+	}
+
+	affected, err := h.service.Purge(ctx, time.Now().Add(-after), anonymise)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"purged": affected, "anonymised": anonymise})
+}
+
 func (h Handler) getPathParamId(w http.ResponseWriter, r *http.Request) (int, error) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		http.Error(w, "invalid player id", http.StatusBadRequest)
+		problem.WriteStatus(w, r, http.StatusBadRequest, "invalid player id")
 		return -1, err
 	}
 	return id, err
@@ -0,0 +1,277 @@
+package player
+
+import (
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	importBatchSize = 500
+	importWorkers   = 4
+)
+
+var ErrImportFormat = problem.NewError(http.StatusBadRequest, "invalid csv")
+
+type ImportStatus string
+
+const (
+	ImportCreated   ImportStatus = "created"
+	ImportValid     ImportStatus = "valid" // dry run only
+	ImportDuplicate ImportStatus = "skipped_duplicate"
+	ImportInvalid   ImportStatus = "invalid"
+	ImportFailed    ImportStatus = "failed"
+)
+
+type ImportRow struct {
+	Line       int          `json:"line"`
+	ResourceId string       `json:"resource_id,omitempty"`
+	Status     ImportStatus `json:"status"`
+	Id         *uint        `json:"id,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun     bool        `json:"dry_run"`
+	Created    int         `json:"created"`
+	Valid      int         `json:"valid"` // dry run only, what would have been created
+	Duplicates int         `json:"skipped_duplicate"`
+	Invalid    int         `json:"invalid"`
+	Failed     int         `json:"failed"`
+	Error      string      `json:"error,omitempty"`
+	Rows       []ImportRow `json:"rows"`
+}
+
+var importColumns = []string{"resource_id", "name", "description"}
+
+// importBatch is owned by exactly one worker, the report is only assembled once the pool is done
+type importBatch struct {
+	rows    []ImportRow
+	players []*Player
+}
+
+// Import streams a CSV with a header row, validates every row with NewPlayer and inserts the
+// valid ones in batched transactions on a worker pool. A dry run validates and checks duplicates only.
+func (s *Service) Import(ctx context.Context, r io.Reader, dryRun bool) (*ImportReport, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if err := validate.Get().Var(r, "required"); err != nil {
+		return nil, err
+	}
+
+	var (
+		parser  = utils.NewCsvParser()
+		pool    = utils.NewWorkerPool(importWorkers, importWorkers)
+		report  = &ImportReport{DryRun: dryRun}
+		rows    []ImportRow // rows settled while parsing (invalid, duplicate within the file)
+		batches []*importBatch
+		batch   = &importBatch{}
+		columns map[string]int
+		seen    = map[string]int{} // resource_id -> line
+		line    = 0
+		poolErr error
+	)
+
+	submit := func() {
+		if len(batch.rows) == 0 || poolErr != nil {
+			return
+		}
+		b := batch
+		batches = append(batches, b)
+		batch = &importBatch{}
+
+		poolErr = pool.Submit(func(poolCtx context.Context) error {
+			if err := poolCtx.Err(); err != nil {
+				return err
+			}
+			return s.importBatch(ctx, b, dryRun)
+		})
+	}
+
+	for row := range parser.Rows(r) {
+		line++
+
+		if columns == nil {
+			var err error
+			if columns, err = parseImportHeader(row); err != nil {
+				_ = pool.Wait()
+				return nil, err
+			}
+			continue
+		}
+
+		result := ImportRow{Line: line, ResourceId: strings.TrimSpace(field(row, columns, "resource_id"))}
+
+		var description *string
+		if d := strings.TrimSpace(field(row, columns, "description")); d != "" {
+			description = &d
+		}
+
+		player, err := NewPlayer(result.ResourceId, strings.TrimSpace(field(row, columns, "name")), description)
+		if err != nil {
+			result.Status, result.Error = ImportInvalid, err.Error()
+			rows = append(rows, result)
+			continue
+		}
+
+		if first, dup := seen[result.ResourceId]; dup {
+			result.Status, result.Error = ImportDuplicate, fmt.Sprintf("duplicate of line %d", first)
+			rows = append(rows, result)
+			continue
+		}
+		seen[result.ResourceId] = line
+
+		batch.rows = append(batch.rows, result)
+		batch.players = append(batch.players, player)
+		if len(batch.rows) == importBatchSize {
+			submit()
+		}
+	}
+
+	if columns == nil && parser.Err() == nil {
+		_ = pool.Wait()
+		return nil, fmt.Errorf("%w: missing header row", ErrImportFormat)
+	}
+	if err := parser.Err(); err != nil {
+		report.Error = fmt.Sprintf("%s: %v", ErrImportFormat, err)
+	} else {
+		submit()
+	}
+
+	if err := pool.Wait(); err != nil && poolErr == nil {
+		poolErr = err
+	}
+
+	for _, b := range batches {
+		rows = append(rows, b.rows...)
+	}
+	report.tally(rows)
+
+	if poolErr != nil {
+		return report, poolErr
+	}
+	if report.Error != "" {
+		return report, ErrImportFormat
+	}
+	return report, nil
+}
+
+// importBatch writes one batch in its own transaction, rows already in the table are skipped
+func (s *Service) importBatch(ctx context.Context, b *importBatch, dryRun bool) error {
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		resourceIds := make([]string, len(b.rows))
+		for i := range b.rows {
+			resourceIds[i] = b.rows[i].ResourceId
+		}
+		existing, err := repo.ExistingResourceIds(ctx, resourceIds)
+		if err != nil {
+			return err
+		}
+
+		for i := range b.rows {
+			row := &b.rows[i]
+
+			switch {
+			case existing[row.ResourceId]:
+				row.Status, row.Error = ImportDuplicate, "resource_id already exists"
+			case dryRun:
+				row.Status = ImportValid
+			default:
+				created, err := repo.Create(ctx, b.players[i])
+				if err != nil {
+					return err
+				}
+				// the stored row, the events carry its timestamps
+				b.players[i] = created
+				row.Id, row.Status = created.Id, ImportCreated
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		for i := range b.rows {
+			b.rows[i].Status, b.rows[i].Id, b.rows[i].Error = ImportFailed, nil, "batch rolled back"
+		}
+		return err
+	}
+
+	for i := range b.rows {
+		if b.rows[i].Status == ImportCreated {
+			s.publish(ctx, EventCreated, b.players[i])
+		}
+	}
+
+	return nil
+}
+
+func (r *ImportReport) tally(rows []ImportRow) {
+	// batches finish out of order, report in file order
+	slices.SortFunc(rows, func(a, b ImportRow) int { return a.Line - b.Line })
+
+	for i := range rows {
+		if rows[i].Status == "" {
+			rows[i].Status, rows[i].Error = ImportFailed, "not processed"
+		}
+
+		switch rows[i].Status {
+		case ImportCreated:
+			r.Created++
+		case ImportValid:
+			r.Valid++
+		case ImportDuplicate:
+			r.Duplicates++
+		case ImportInvalid:
+			r.Invalid++
+		default:
+			r.Failed++
+		}
+	}
+
+	r.Rows = rows
+	if r.Rows == nil {
+		r.Rows = []ImportRow{}
+	}
+}
+
+func parseImportHeader(row []string) (map[string]int, error) {
+	columns := make(map[string]int, len(row))
+
+	for i, name := range row {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF"))) // Excel likes a BOM
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("%w: unknown column '%s'", ErrImportFormat, name)
+		}
+		columns[name] = i
+	}
+
+	for _, required := range importColumns[:2] {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("%w: missing column '%s'", ErrImportFormat, required)
+		}
+	}
+
+	return columns, nil
+}
+
+func field(row []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
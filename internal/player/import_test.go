@@ -0,0 +1,34 @@
+package player
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportTally(t *testing.T) {
+	req := require.New(t)
+
+	report := &ImportReport{DryRun: true}
+	report.tally([]ImportRow{
+		{Line: 4, Status: ImportDuplicate},
+		{Line: 2, Status: ImportValid},
+		{Line: 3, Status: ImportValid},
+		{Line: 5, Status: ImportInvalid},
+		{Line: 6},
+	})
+
+	// a dry run writes nothing
+	req.Equal(0, report.Created)
+	req.Equal(2, report.Valid)
+	req.Equal(1, report.Duplicates)
+	req.Equal(1, report.Invalid)
+	req.Equal(1, report.Failed)
+	req.Equal(2, report.Rows[0].Line)
+	req.Equal("not processed", report.Rows[4].Error)
+
+	report = &ImportReport{}
+	report.tally([]ImportRow{{Line: 2, Status: ImportCreated}})
+	req.Equal(1, report.Created)
+	req.Equal(0, report.Valid)
+}
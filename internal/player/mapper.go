@@ -20,6 +20,7 @@ func ToDTO(p *Player) (*DTO, error) {
 		CreatedBy:   p.CreatedBy,
 		UpdatedAt:   p.UpdatedAt,
 		UpdatedBy:   p.UpdatedBy,
+		DeletedAt:   p.DeletedAt,
 	}, nil
 }
 
@@ -3,16 +3,24 @@ package player
 import (
 	"Go-lab/internal/audit"
 	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/problem"
 	"Go-lab/internal/utils/validate"
+	"net/http"
 	"time"
 )
 
+var (
+	ErrNotFound   = problem.NewError(http.StatusNotFound, "player not found")
+	ErrConflict   = problem.NewError(http.StatusPreconditionFailed, "player already modified by another request, please refresh and retry.")
+	ErrNotInTrash = problem.NewError(http.StatusConflict, "player is not in the trash or was modified by another request, please refresh and retry.")
+)
+
 type Player struct {
 	audit.Auditable
 	Id          *uint      `db:"id"`
 	ResourceId  string     `db:"resource_id" validate:"required,notblank,max=100"`
 	Name        string     `db:"name" validate:"required,notblank,max=50"`
-	Description *string    `validate:"min=1,max=50"`
+	Description *string    `validate:"omitnil,min=1,max=50"` // NULL is fine, blank is not
 	LastCheckin *time.Time `db:"last_checkin"`
 }
 
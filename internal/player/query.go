@@ -0,0 +1,46 @@
+package player
+
+import (
+	"Go-lab/internal/utils/query"
+)
+
+// querySchema whitelists what GET /player can filter and sort on.
+var querySchema = query.Schema[Player]{
+	Fields: map[string]query.Field[Player]{
+		"name": {
+			Column:   "name",
+			Kind:     query.String,
+			Ops:      []query.Op{query.Eq, query.Prefix, query.Contains},
+			Sortable: true,
+			Key:      func(p Player) any { return p.Name },
+		},
+		"resource_id": {
+			Column: "resource_id",
+			Kind:   query.String,
+			Ops:    []query.Op{query.Eq, query.In},
+		},
+		"last_checkin": {
+			Column:   "last_checkin",
+			Kind:     query.Time,
+			Nullable: true,
+			Ops:      []query.Op{query.Lt, query.Lte, query.Gt, query.Gte},
+			Sortable: true,
+			Key:      func(p Player) any { return p.LastCheckin },
+		},
+		"created_at": {
+			Column:   "created_at",
+			Kind:     query.Time,
+			Ops:      []query.Op{query.Lt, query.Lte, query.Gt, query.Gte},
+			Sortable: true,
+			Key:      func(p Player) any { return p.CreatedAt },
+		},
+	},
+	IdColumn: "id",
+	Id: func(p Player) uint {
+		if p.Id == nil {
+			return 0
+		}
+		return *p.Id
+	},
+	DefaultSort: []query.Sort{{Field: "name"}},
+}
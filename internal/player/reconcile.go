@@ -0,0 +1,306 @@
+package player
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/query"
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"Go-lab/pkg/playerclient"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type ReconcileFlagKind string
+
+const (
+	LocalOnly  ReconcileFlagKind = "local_only"  // not in the remote system, left alone
+	RemoteOnly ReconcileFlagKind = "remote_only" // created locally unless it is a dry run
+	Failed     ReconcileFlagKind = "failed"      // could not be created or updated
+
+	// in the local trash, neither created again nor restored: the delete was the user's
+	LocalDeleted ReconcileFlagKind = "local_deleted"
+)
+
+// ReconcileRun is the summary row of one reconciliation
+type ReconcileRun struct {
+	Id           *uint     `db:"id"`
+	StartedAt    time.Time `db:"started_at"`
+	FinishedAt   time.Time `db:"finished_at"`
+	DryRun       bool      `db:"dry_run"`
+	RemoteCount  uint      `db:"remote_count"`
+	LocalCount   uint      `db:"local_count"`
+	Created      uint      `db:"created"`
+	Updated      uint      `db:"updated"`
+	Unchanged    uint      `db:"unchanged"`
+	LocalOnly    uint      `db:"local_only"`
+	RemoteOnly   uint      `db:"remote_only"`
+	LocalDeleted uint      `db:"local_deleted"`
+	Failed       uint      `db:"failed"`
+	Error        *string   `db:"error"`
+
+	Flags []ReconcileFlag `db:"-"`
+}
+
+type ReconcileFlag struct {
+	RunId      uint              `db:"run_id"`
+	ResourceId string            `db:"resource_id"`
+	Kind       ReconcileFlagKind `db:"kind"`
+	PlayerId   *uint             `db:"player_id"`
+	Detail     *string           `db:"detail"`
+}
+
+func (r *ReconcileRun) flag(kind ReconcileFlagKind, resourceId string, playerId *uint, err error) {
+	f := ReconcileFlag{Kind: kind, ResourceId: resourceId, PlayerId: playerId}
+	if err != nil {
+		detail := truncate(err.Error(), 255)
+		f.Detail = &detail
+	}
+	r.Flags = append(r.Flags, f)
+}
+
+// NewReconcileService syncs the local players with the remote player system on a schedule
+func NewReconcileService(service *Service, cfg config.ReconcileConfig) utils.Service {
+	return utils.NewScheduledService("player-reconcile", cfg.Interval, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+		run, err := service.Reconcile(ctx, cfg.DryRun, cfg.PageSize)
+		if err != nil {
+			return err
+		}
+
+		slog.InfoContext(ctx, "player reconciliation", "run", *run.Id, "dry_run", run.DryRun,
+			"remote", run.RemoteCount, "local", run.LocalCount, "created", run.Created, "updated", run.Updated,
+			"local_only", run.LocalOnly, "remote_only", run.RemoteOnly, "local_deleted", run.LocalDeleted, "failed", run.Failed)
+		return nil
+	})
+}
+
+// Reconcile pages through the remote players and upserts them by resource_id, one transaction per page.
+// Local players the remote system does not know are only flagged, as are remote ones whose local copy is in the
+// trash. The run summary is written even for a dry run,
+// and when the remote side fails half way, with the error.
+func (s *Service) Reconcile(ctx context.Context, dryRun bool, pageSize uint) (*ReconcileRun, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if err := validate.Get().Var(s.api, "required"); err != nil {
+		return nil, err
+	}
+
+	run := &ReconcileRun{StartedAt: time.Now(), DryRun: dryRun}
+
+	// the trash too, resource_id is not unique and a trashed player must not come back as a second row
+	local := map[string]Player{}
+	err := s.Export(ctx, query.Query{Sort: querySchema.DefaultSort, IncludeDeleted: true}, func(p *Player) error {
+		local[p.ResourceId] = *p
+		if p.DeletedAt == nil {
+			run.LocalCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	opts := playerclient.ListOptions{Limit: pageSize}
+	var runErr error
+
+	for {
+		page, err := s.api.List(ctx, opts)
+		if err != nil {
+			runErr = fmt.Errorf("remote players: %w", err)
+			break
+		}
+
+		if err = s.reconcilePage(ctx, run, page.Items, local, seen); err != nil {
+			runErr = err
+			break
+		}
+
+		if page.NextCursor == nil {
+			break
+		}
+		opts.Cursor = *page.NextCursor
+	}
+
+	// without the full remote listing the local only flags would be wrong
+	if runErr == nil {
+		for resourceId, p := range local {
+			if !seen[resourceId] && p.DeletedAt == nil {
+				run.LocalOnly++
+				run.flag(LocalOnly, resourceId, p.Id, nil)
+			}
+		}
+	} else {
+		msg := truncate(runErr.Error(), 1000)
+		run.Error = &msg
+	}
+
+	run.FinishedAt = time.Now()
+
+	err = s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		return repo.InsertReconcileRun(ctx, run)
+	})
+	if err != nil {
+		return nil, errors.Join(runErr, err)
+	}
+
+	return run, runErr
+}
+
+// reconcileChange is a remote player to create, local is nil, or to copy onto local
+type reconcileChange struct {
+	remote playerclient.Player
+	local  *Player
+}
+
+// diffPage compares a page of remote players with the local ones and counts what needs no write
+func diffPage(run *ReconcileRun, remote []playerclient.Player, local map[string]Player, seen map[string]bool) []reconcileChange {
+	var changes []reconcileChange
+
+	for _, rp := range remote {
+		if seen[rp.ResourceId] {
+			continue // moved between pages while we were paging
+		}
+		seen[rp.ResourceId] = true
+		run.RemoteCount++
+
+		lp, ok := local[rp.ResourceId]
+		switch {
+		case !ok:
+			run.RemoteOnly++
+			changes = append(changes, reconcileChange{remote: rp})
+		case lp.DeletedAt != nil:
+			run.LocalDeleted++
+			run.flag(LocalDeleted, rp.ResourceId, lp.Id, nil)
+		case lp.Name == rp.Name && equalPtr(lp.Description, rp.Description):
+			run.Unchanged++
+		default:
+			changes = append(changes, reconcileChange{remote: rp, local: &lp})
+		}
+	}
+
+	return changes
+}
+
+// add counts the writes of a page into the run
+func (r *ReconcileRun) add(page *ReconcileRun) {
+	r.Created += page.Created
+	r.Updated += page.Updated
+	r.Failed += page.Failed
+	r.Flags = append(r.Flags, page.Flags...)
+}
+
+func (s *Service) reconcilePage(ctx context.Context, run *ReconcileRun, remote []playerclient.Player, local map[string]Player, seen map[string]bool) error {
+	changes := diffPage(run, remote, local, seen)
+
+	if run.DryRun {
+		for _, c := range changes {
+			if c.local == nil {
+				run.flag(RemoteOnly, c.remote.ResourceId, nil, nil)
+			} else {
+				run.Updated++
+			}
+		}
+		return nil
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// counted into run once committed, a rolled back page wrote nothing
+	var page ReconcileRun
+	var created, updated []*Player
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range changes {
+			if c.local == nil {
+				player := &Player{
+					ResourceId:  c.remote.ResourceId,
+					Name:        c.remote.Name,
+					Description: c.remote.Description,
+				}
+				if err := player.Validate(); err != nil {
+					page.Failed++
+					page.flag(Failed, c.remote.ResourceId, nil, err)
+					continue
+				}
+				p, err := repo.Create(ctx, player)
+				if err != nil {
+					return err
+				}
+				created = append(created, p)
+				page.Created++
+				page.flag(RemoteOnly, c.remote.ResourceId, p.Id, nil)
+				continue
+			}
+
+			update := &UpdateDto{
+				Id:          c.local.Id,
+				Name:        c.remote.Name,
+				Description: c.remote.Description,
+				Version:     etag.Exact(c.local.UpdatedAt),
+			}
+			if err := (&Player{ResourceId: c.local.ResourceId, Name: update.Name, Description: update.Description}).Validate(); err != nil {
+				page.Failed++
+				page.flag(Failed, c.remote.ResourceId, c.local.Id, err)
+				continue
+			}
+			p, err := repo.Update(ctx, update)
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					return err
+				}
+				// changed locally since we read it, the next run picks it up
+				page.Failed++
+				page.flag(Failed, c.remote.ResourceId, c.local.Id, ErrConflict)
+				continue
+			}
+			updated = append(updated, p)
+			page.Updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	run.add(&page)
+	s.publish(ctx, EventCreated, created...)
+	s.publish(ctx, EventUpdated, updated...)
+	return nil
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
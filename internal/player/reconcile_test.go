@@ -0,0 +1,111 @@
+package player
+
+import (
+	"Go-lab/internal/audit"
+	"Go-lab/pkg/playerclient"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffPage(t *testing.T) {
+	req := require.New(t)
+
+	id1, id2 := uint(1), uint(2)
+	desc := "left back"
+	local := map[string]Player{
+		"r1": {Id: &id1, ResourceId: "r1", Name: "Ann"},
+		"r2": {Id: &id2, ResourceId: "r2", Name: "Bob", Description: &desc},
+	}
+	seen := map[string]bool{}
+	run := &ReconcileRun{}
+
+	changes := diffPage(run, []playerclient.Player{
+		{ResourceId: "r1", Name: "Ann"},
+		{ResourceId: "r2", Name: "Bob"},
+		{ResourceId: "r3", Name: "Cid"},
+	}, local, seen)
+
+	req.Len(changes, 2)
+	req.Equal("r2", changes[0].remote.ResourceId)
+	req.Equal(&id2, changes[0].local.Id)
+	req.Equal("r3", changes[1].remote.ResourceId)
+	req.Nil(changes[1].local)
+	req.Equal(uint(3), run.RemoteCount)
+	req.Equal(uint(1), run.Unchanged)
+	req.Equal(uint(1), run.RemoteOnly)
+
+	// moved to the next page while paging, counted once
+	changes = diffPage(run, []playerclient.Player{{ResourceId: "r3", Name: "Cid"}}, local, seen)
+	req.Empty(changes)
+	req.Equal(uint(3), run.RemoteCount)
+}
+
+func TestReconcilePageDryRun(t *testing.T) {
+	req := require.New(t)
+
+	id := uint(1)
+	local := map[string]Player{"r1": {Id: &id, ResourceId: "r1", Name: "Ann"}}
+	run := &ReconcileRun{DryRun: true}
+
+	// a dry run never gets to the database
+	err := (&Service{}).reconcilePage(context.Background(), run, []playerclient.Player{
+		{ResourceId: "r1", Name: "Anne"},
+		{ResourceId: "r2", Name: "Bob"},
+	}, local, map[string]bool{})
+	req.NoError(err)
+
+	req.Equal(uint(0), run.Created)
+	req.Equal(uint(1), run.Updated)
+	req.Equal(uint(1), run.RemoteOnly)
+	req.Equal([]ReconcileFlag{{ResourceId: "r2", Kind: RemoteOnly}}, run.Flags)
+}
+
+func TestReconcileRunAdd(t *testing.T) {
+	req := require.New(t)
+
+	id := uint(7)
+	run := &ReconcileRun{Created: 1, Flags: []ReconcileFlag{{ResourceId: "r0", Kind: RemoteOnly}}}
+
+	var page ReconcileRun
+	page.Created++
+	page.flag(RemoteOnly, "r1", &id, nil)
+	page.Failed++
+	page.flag(Failed, "r2", nil, errors.New("name is required"))
+
+	run.add(&page)
+	req.Equal(uint(2), run.Created)
+	req.Equal(uint(1), run.Failed)
+	req.Len(run.Flags, 3)
+	req.Equal(&id, run.Flags[1].PlayerId)
+	req.Equal("name is required", *run.Flags[2].Detail)
+}
+
+func TestReconcilePageLocalDeleted(t *testing.T) {
+	req := require.New(t)
+
+	id := uint(1)
+	deletedAt := time.Now()
+	local := map[string]Player{
+		"r1": {Auditable: audit.Auditable{DeletedAt: &deletedAt}, Id: &id, ResourceId: "r1", Name: "Ann"},
+	}
+
+	// nothing to write, the database is never reached: a trashed player is not created a second time
+	for _, dryRun := range []bool{false, true} {
+		run := &ReconcileRun{DryRun: dryRun}
+		err := (&Service{}).reconcilePage(context.Background(), run, []playerclient.Player{
+			{ResourceId: "r1", Name: "Anne"},
+		}, local, map[string]bool{})
+		req.NoError(err)
+
+		req.Equal(uint(1), run.RemoteCount)
+		req.Equal(uint(0), run.RemoteOnly)
+		req.Equal(uint(0), run.Created)
+		req.Equal(uint(0), run.Updated)
+		req.Equal(uint(1), run.LocalDeleted)
+		req.Equal([]ReconcileFlag{{ResourceId: "r1", Kind: LocalDeleted, PlayerId: &id}}, run.Flags)
+	}
+}
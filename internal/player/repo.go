@@ -1,11 +1,16 @@
 package player
 
 import (
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/outbox"
 	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/query"
 	"Go-lab/internal/utils/validate"
 	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -23,7 +28,8 @@ func NewRepo(tx *sqlx.Tx) (*Repo, error) {
 	return &Repo{tx: tx}, nil
 }
 
-func (r *Repo) Create(ctx context.Context, player *Player) (*uint, error) {
+// Create inserts the player and returns the row as stored, with its id and audit columns
+func (r *Repo) Create(ctx context.Context, player *Player) (*Player, error) {
 	if err := validate.Get().Var(player, "required"); err != nil {
 		return nil, err
 	}
@@ -47,7 +53,24 @@ func (r *Repo) Create(ctx context.Context, player *Player) (*uint, error) {
 
 	id := uint(lastInsertedId) // so annoying, cannot be inlined
 
-	return &id, nil
+	created, err := r.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.writeOutbox(ctx, EventCreated, created); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// writeOutbox records the event in the transaction of the change, the relay publishes it after the commit
+func (r *Repo) writeOutbox(ctx context.Context, t EventType, player *Player) error {
+	dto, err := ToDTO(player)
+	if err != nil {
+		return err
+	}
+	return outbox.Write(ctx, r.tx, "player", *player.Id, string(t), dto)
 }
 
 //goland:noinspection SqlNoDataSourceInspection,SqlResolve
@@ -119,14 +142,24 @@ func (r *Repo) FindByResourceId(ctx context.Context, resourceId string) (*Player
 	return &player, nil
 }
 
-func (r *Repo) FindAll(ctx context.Context, paging paging.Paging) ([]Player, error) {
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindAll(ctx context.Context, p paging.Paging, q query.Query) ([]Player, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
 		return nil, err
 	}
-	var players []Player
 
-	if err := r.tx.SelectContext(ctx, &players,
-		`
+	where, args := whereOf(q)
+
+	if p.IsKeyset() && p.Cursor != nil {
+		keyset, keysetArgs, err := querySchema.Keyset(q, p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where += " AND " + keyset
+		args = append(args, keysetArgs...)
+	}
+
+	stmt := `
 		SELECT
 			id,
 			resource_id,
@@ -136,27 +169,219 @@ func (r *Repo) FindAll(ctx context.Context, paging paging.Paging) ([]Player, err
 			created_at,
 			created_by,
 			updated_at,
-			updated_by
+			updated_by,
+			deleted_at
 		FROM
 			player_entity
 		WHERE
-		    deleted_at IS NULL
+			` + where + `
 		ORDER BY
-			name ASC
-			LIMIT ? OFFSET ?`,
-		paging.Limit, paging.Offset(),
-	); err != nil {
+			` + querySchema.OrderBy(q, p.Backward()) + `
+		LIMIT ?`
+	args = append(args, p.Fetch())
+
+	if !p.IsKeyset() {
+		stmt += " OFFSET ?"
+		args = append(args, p.Offset())
+	}
+
+	var players []Player
+
+	if err := r.tx.SelectContext(ctx, &players, stmt, args...); err != nil {
 		return nil, err
 	}
 
 	return players, nil
 }
 
-func (r *Repo) Checkin(ctx context.Context, id uint, updatedAt *time.Time) (*Player, error) {
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Count(ctx context.Context, q query.Query) (uint, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	where, args := whereOf(q)
+
+	var count uint
+
+	if err := r.tx.GetContext(ctx, &count, `
+		SELECT
+			COUNT(*)
+		FROM
+			player_entity
+		WHERE
+			`+where,
+		args...,
+	); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CollectionVersion sums up the rows matching a query, enough to tell whether a listing has changed
+type CollectionVersion struct {
+	Count        uint       `db:"count"`
+	MaxUpdatedAt *time.Time `db:"max_updated_at"`
+	MaxCreatedAt *time.Time `db:"max_created_at"`
+}
+
+// LastModified is the newest of the creation and update times
+func (v CollectionVersion) LastModified() *time.Time {
+	if v.MaxUpdatedAt == nil || (v.MaxCreatedAt != nil && v.MaxCreatedAt.After(*v.MaxUpdatedAt)) {
+		return v.MaxCreatedAt
+	}
+	return v.MaxUpdatedAt
+}
+
+// Version reads the CollectionVersion of a query, an index friendly aggregate instead of the full select
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Version(ctx context.Context, q query.Query) (*CollectionVersion, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	where, args := whereOf(q)
+
+	var version CollectionVersion
+
+	if err := r.tx.GetContext(ctx, &version, `
+		SELECT
+			COUNT(*) AS count,
+			MAX(updated_at) AS max_updated_at,
+			MAX(created_at) AS max_created_at
+		FROM
+			player_entity
+		WHERE
+			`+where,
+		args...,
+	); err != nil {
+		return nil, err
+	}
+
+	return &version, nil
+}
+
+// whereOf never returns an empty clause, so callers can always append with AND
+func whereOf(q query.Query) (string, []any) {
+	preds := []string{"1 = 1"}
+	switch {
+	case q.OnlyDeleted:
+		preds = append(preds, "deleted_at IS NOT NULL", "purged_at IS NULL")
+	case !q.IncludeDeleted:
+		preds = append(preds, "deleted_at IS NULL")
+	}
+
+	filters, args := querySchema.Where(q)
+	if filters != "" {
+		preds = append(preds, filters)
+	}
+
+	return strings.Join(preds, " AND "), args
+}
+
+// Stream walks the matching players straight off the database cursor, nothing is buffered
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Stream(ctx context.Context, q query.Query, fn func(*Player) error) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+	if err := validate.Get().Var(fn, "required"); err != nil {
+		return err
+	}
+
+	where, args := whereOf(q)
+
+	rows, err := r.tx.QueryxContext(ctx, `
+		SELECT
+			id,
+			resource_id,
+			name,
+			description,
+			last_checkin,
+			created_at,
+			created_by,
+			updated_at,
+			updated_by,
+			deleted_at
+		FROM
+			player_entity
+		WHERE
+			`+where+`
+		ORDER BY
+			`+querySchema.OrderBy(q, false),
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var player Player
+		if err := rows.StructScan(&player); err != nil {
+			return err
+		}
+		if err := fn(&player); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ExistingResourceIds reports which of the given resource ids are already taken by a live player
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) ExistingResourceIds(ctx context.Context, resourceIds []string) (map[string]bool, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
 		return nil, err
 	}
 
+	res := make(map[string]bool, len(resourceIds))
+	if len(resourceIds) == 0 {
+		return res, nil
+	}
+
+	stmt, args, err := sqlx.In(`
+		SELECT
+			resource_id
+		FROM
+			player_entity
+		WHERE
+			resource_id IN (?)
+		AND
+			deleted_at IS NULL`,
+		resourceIds,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []string
+	if err := r.tx.SelectContext(ctx, &existing, r.tx.Rebind(stmt), args...); err != nil {
+		return nil, err
+	}
+
+	for _, id := range existing {
+		res[id] = true
+	}
+
+	return res, nil
+}
+
+// Checkin records a visit in player_checkin and keeps last_checkin in step, both in the caller's transaction
+func (r *Repo) Checkin(ctx context.Context, id uint, version etag.Versions, checkin *CheckinDto) (*Player, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if checkin == nil {
+		checkin = &CheckinDto{}
+	}
+
+	match, versions := version.Predicate("updated_at")
 	res, err := r.tx.ExecContext(ctx, `
 		UPDATE
 			player_entity
@@ -165,8 +390,8 @@ func (r *Repo) Checkin(ctx context.Context, id uint, updatedAt *time.Time) (*Pla
 		WHERE
 			id = ?
 		AND
-			updated_at <=> ?`,
-		id, updatedAt,
+			`+match,
+		append([]any{id}, versions...)...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("update player %d last_checkin: %w", id, err)
@@ -180,55 +405,183 @@ func (r *Repo) Checkin(ctx context.Context, id uint, updatedAt *time.Time) (*Pla
 		return nil, sql.ErrNoRows
 	}
 
-	return r.FindById(ctx, id)
+	// copy last_checkin rather than taking a new timestamp, the two must agree
+	if _, err = r.tx.ExecContext(ctx, `
+		INSERT INTO player_checkin (player_id, checked_in_at, source, note)
+		SELECT
+			id,
+			last_checkin,
+			?,
+			?
+		FROM
+			player_entity
+		WHERE
+			id = ?`,
+		checkin.Source, checkin.Note, id,
+	); err != nil {
+		return nil, fmt.Errorf("insert checkin for player %d: %w", id, err)
+	}
+
+	player, err := r.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.writeOutbox(ctx, EventCheckedIn, player); err != nil {
+		return nil, err
+	}
+
+	return player, nil
 }
 
-func (r *Repo) Update(ctx context.Context, dto *UpdateDto) error {
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindCheckins(ctx context.Context, playerId uint, p paging.Paging, q query.Query) ([]Checkin, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
-		return err
+		return nil, err
+	}
+
+	where, args := checkinWhereOf(playerId, q)
+
+	if p.IsKeyset() && p.Cursor != nil {
+		keyset, keysetArgs, err := checkinSchema.Keyset(q, p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where += " AND " + keyset
+		args = append(args, keysetArgs...)
+	}
+
+	stmt := `
+		SELECT
+			id,
+			player_id,
+			checked_in_at,
+			checked_in_by,
+			source,
+			note
+		FROM
+			player_checkin
+		WHERE
+			` + where + `
+		ORDER BY
+			` + checkinSchema.OrderBy(q, p.Backward()) + `
+		LIMIT ?`
+	args = append(args, p.Fetch())
+
+	if !p.IsKeyset() {
+		stmt += " OFFSET ?"
+		args = append(args, p.Offset())
+	}
+
+	var checkins []Checkin
+
+	if err := r.tx.SelectContext(ctx, &checkins, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	return checkins, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) CountCheckins(ctx context.Context, playerId uint, q query.Query) (uint, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	where, args := checkinWhereOf(playerId, q)
+
+	var count uint
+
+	if err := r.tx.GetContext(ctx, &count, `
+		SELECT
+			COUNT(*)
+		FROM
+			player_checkin
+		WHERE
+			`+where,
+		args...,
+	); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func checkinWhereOf(playerId uint, q query.Query) (string, []any) {
+	where, args := checkinSchema.Where(q)
+	if where == "" {
+		return "player_id = ?", []any{playerId}
+	}
+	return "player_id = ? AND " + where, append([]any{playerId}, args...)
+}
+
+// Update returns the updated player, sql.ErrNoRows when updated_at does not match
+func (r *Repo) Update(ctx context.Context, dto *UpdateDto) (*Player, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
 	}
 
 	if err := validate.Get().Var(dto, "required"); err != nil {
-		return err
+		return nil, err
 	}
 
 	if dto.Id == nil {
-		return fmt.Errorf("id is required")
+		return nil, fmt.Errorf("id is required")
 	}
 
-	res, err := r.tx.NamedExecContext(ctx, `
+	query, args, err := sqlx.Named(`
 		UPDATE
 			player_entity
 		SET
 			name = :name,
 			description = :description
 		WHERE
-			id = :id
+			id = :id`, dto)
+	if err != nil {
+		return nil, fmt.Errorf("bind update of player %d: %w", *dto.Id, err)
+	}
+
+	match, versions := dto.Version.Predicate("updated_at")
+	res, err := r.tx.ExecContext(ctx, query+`
 		AND
-			updated_at <=> :updated_at`,
-		&dto,
+			`+match,
+		append(args, versions...)...,
 	)
 	if err != nil {
-		return fmt.Errorf("update player %d: %w", *dto.Id, err)
+		return nil, fmt.Errorf("update player %d: %w", *dto.Id, err)
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("rows affected check for player %d: %w", *dto.Id, err)
+		return nil, fmt.Errorf("rows affected check for player %d: %w", *dto.Id, err)
 	}
 	if affected == 0 {
-		return sql.ErrNoRows
+		return nil, sql.ErrNoRows
 	}
 
-	return nil
+	player, err := r.FindById(ctx, *dto.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.writeOutbox(ctx, EventUpdated, player); err != nil {
+		return nil, err
+	}
+
+	return player, nil
 }
 
-// Delete Soft Deletes only!
-func (r *Repo) Delete(ctx context.Context, id uint, updatedAt *time.Time) error {
+// Delete Soft Deletes only! Returns the player as it was before.
+func (r *Repo) Delete(ctx context.Context, id uint, version etag.Versions) (*Player, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
-		return err
+		return nil, err
 	}
 
+	// the last state, for the event
+	player, err := r.FindById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	match, versions := version.Predicate("updated_at")
 	res, err := r.tx.ExecContext(ctx, `
 		UPDATE
 			player_entity
@@ -237,13 +590,54 @@ func (r *Repo) Delete(ctx context.Context, id uint, updatedAt *time.Time) error
 		WHERE
 			id = ?
 		AND
-			updated_at <=> ?
+			`+match+`
 		AND
 			deleted_at IS NULL`,
-		id, updatedAt,
+		append([]any{id}, versions...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("delete player %d: %w", id, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("rows affected check for player %d: %w", id, err)
+	}
+	if affected == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	if err = r.writeOutbox(ctx, EventDeleted, player); err != nil {
+		return nil, err
+	}
+
+	return player, nil
+}
+
+// Restore takes a player back out of the trash, unless it was anonymised in the meantime
+func (r *Repo) Restore(ctx context.Context, id uint, version etag.Versions) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	match, versions := version.Predicate("updated_at")
+	res, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			player_entity
+		SET
+			deleted_at = NULL
+		WHERE
+			id = ?
+		AND
+			`+match+`
+		AND
+			deleted_at IS NOT NULL
+		AND
+			purged_at IS NULL`,
+		append([]any{id}, versions...)...,
 	)
 	if err != nil {
-		return fmt.Errorf("delete player %d: %w", id, err)
+		return fmt.Errorf("restore player %d: %w", id, err)
 	}
 
 	affected, err := res.RowsAffected()
@@ -254,5 +648,139 @@ func (r *Repo) Delete(ctx context.Context, id uint, updatedAt *time.Time) error
 		return sql.ErrNoRows
 	}
 
+	player, err := r.FindById(ctx, id)
+	if err != nil {
+		return err
+	}
+	return r.writeOutbox(ctx, EventUpdated, player)
+}
+
+// Anonymise scrubs the personal data of players deleted before the given time, the rows stay for the audit trail
+func (r *Repo) Anonymise(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			player_entity
+		SET
+			resource_id = CONCAT('purged-', id),
+			name = 'purged',
+			description = NULL,
+			purged_at = CURRENT_TIMESTAMP
+		WHERE
+			deleted_at < ?
+		AND
+			purged_at IS NULL`,
+		deletedBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("anonymise players: %w", err)
+	}
+
+	// notes are free text, they go too
+	if _, err = r.tx.ExecContext(ctx, `
+		UPDATE
+			player_checkin c
+		JOIN
+			player_entity p ON p.id = c.player_id
+		SET
+			c.note = NULL
+		WHERE
+			p.purged_at IS NOT NULL
+		AND
+			c.note IS NOT NULL`,
+	); err != nil {
+		return 0, fmt.Errorf("anonymise player checkins: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// Purge hard deletes players deleted before the given time. The delete trigger only lets this through
+// while @allow_player_purge is set.
+func (r *Repo) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	if _, err := r.tx.ExecContext(ctx, "SET @allow_player_purge = 1"); err != nil {
+		return 0, err
+	}
+	// rolling back keeps user variables, and a cancelled ctx must not skip this; the next checkout of the
+	// connection clears it as well, see dbutils
+	defer func() {
+		if _, err := r.tx.ExecContext(context.WithoutCancel(ctx), "SET @allow_player_purge = NULL"); err != nil {
+			log.Printf("failed to reset @allow_player_purge: %v", err)
+		}
+	}()
+
+	res, err := r.tx.ExecContext(ctx, `
+		DELETE FROM
+			player_entity
+		WHERE
+			deleted_at < ?`,
+		deletedBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("purge players: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// InsertReconcileRun writes the summary of a reconciliation and its flags, and sets the run id
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) InsertReconcileRun(ctx context.Context, run *ReconcileRun) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+	if err := validate.Get().Var(run, "required"); err != nil {
+		return err
+	}
+
+	res, err := r.tx.NamedExecContext(ctx, `
+		INSERT INTO player_reconcile_run
+			(started_at, finished_at, dry_run, remote_count, local_count, created, updated, unchanged, local_only, remote_only, local_deleted, failed, error)
+		VALUES
+			(:started_at, :finished_at, :dry_run, :remote_count, :local_count, :created, :updated, :unchanged, :local_only, :remote_only, :local_deleted, :failed, :error)`,
+		run,
+	)
+	if err != nil {
+		return fmt.Errorf("insert reconcile run: %w", err)
+	}
+
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("insert reconcile run (cannot get lastInsertId): %w", err)
+	}
+	id := uint(lastInsertedId)
+	run.Id = &id
+
+	if len(run.Flags) == 0 {
+		return nil
+	}
+
+	for i := range run.Flags {
+		run.Flags[i].RunId = id
+	}
+
+	// batched, a first run against a big remote system flags a lot
+	const batch = 500
+	for start := 0; start < len(run.Flags); start += batch {
+		end := min(start+batch, len(run.Flags))
+		if _, err = r.tx.NamedExecContext(ctx, `
+			INSERT INTO player_reconcile_flag
+				(run_id, resource_id, kind, player_id, detail)
+			VALUES
+				(:run_id, :resource_id, :kind, :player_id, :detail)`,
+			run.Flags[start:end],
+		); err != nil {
+			return fmt.Errorf("insert reconcile flags: %w", err)
+		}
+	}
+
 	return nil
 }
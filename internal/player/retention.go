@@ -0,0 +1,28 @@
+package player
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/session"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// NewRetentionService sweeps the trash on a schedule, anonymising or removing players deleted
+// longer ago than the configured retention period.
+func NewRetentionService(service *Service, cfg config.RetentionConfig) utils.Service {
+	return utils.NewScheduledService("player-retention", cfg.Interval, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+		affected, err := service.Purge(ctx, time.Now().Add(-cfg.After), cfg.Anonymise)
+		if err != nil {
+			return err
+		}
+
+		if affected > 0 {
+			slog.InfoContext(ctx, "player retention sweep", "purged", affected, "anonymised", cfg.Anonymise)
+		}
+		return nil
+	})
+}
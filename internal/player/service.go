@@ -1,10 +1,15 @@
 package player
 
 import (
+	"Go-lab/internal/middleware/etag"
 	"Go-lab/internal/utils/dbutils"
 	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/query"
 	"Go-lab/internal/utils/validate"
 	"context"
+	"database/sql"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -14,6 +19,9 @@ type Service struct {
 	db  *dbutils.DbUtils
 	api *API
 	ctx context.Context
+
+	mu        sync.RWMutex
+	listeners []Listener
 }
 
 func NewService(dbUtils *dbutils.DbUtils, api *API) *Service {
@@ -34,7 +42,32 @@ func (s *Service) Create(ctx context.Context, player *Player) (*uint, error) {
 		return nil, err
 	}
 
-	var id *uint
+	var created *Player
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		created, err = repo.Create(ctx, player)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, EventCreated, created)
+
+	return created.Id, nil
+}
+
+func (s *Service) FindAll(ctx context.Context, p paging.Paging, q query.Query) (*paging.Page[Player], error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var page paging.Page[Player]
 
 	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
 		repo, err := s.createPlayerRepo(tx)
@@ -42,44 +75,70 @@ func (s *Service) Create(ctx context.Context, player *Player) (*uint, error) {
 			return err
 		}
 
-		id, err = repo.Create(ctx, player)
+		players, err := repo.FindAll(ctx, p, q)
 		if err != nil {
 			return err
 		}
+		page = paging.NewPage(players, p, func(player Player) paging.Cursor {
+			return querySchema.Cursor(q, player)
+		})
+
+		if p.Total {
+			total, err := repo.Count(ctx, q)
+			if err != nil {
+				return err
+			}
+			page.Total = &total
+		}
 		return nil
 	})
+
 	if err != nil {
 		return nil, err
 	}
 
-	return id, nil
+	return &page, nil
 }
 
-func (s *Service) FindAll(ctx context.Context, paging paging.Paging) ([]Player, error) {
+// Version tells cheaply whether the result of a FindAll with the same query could have changed
+func (s *Service) Version(ctx context.Context, q query.Query) (*CollectionVersion, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
 		return nil, err
 	}
 
-	var players []Player
+	var version *CollectionVersion
 
 	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
-		var err error
 		repo, err := s.createPlayerRepo(tx)
 		if err != nil {
 			return err
 		}
 
-		if players, err = repo.FindAll(ctx, paging); err != nil {
-			return err
-		}
-		return nil
+		version, err = repo.Version(ctx, q)
+		return err
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return players, nil
+	return version, nil
+}
+
+// Export hands every matching player to fn while the rows are read, for exports of any size
+func (s *Service) Export(ctx context.Context, q query.Query, fn func(*Player) error) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	return s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		return repo.Stream(ctx, q, fn)
+	})
 }
 
 func (s *Service) FindById(ctx context.Context, id uint) (*Player, error) {
@@ -141,10 +200,13 @@ func (s *Service) FindByResourceId(ctx context.Context, resourceId string) (*Pla
 	return player, nil
 }
 
-func (s *Service) Checkin(ctx context.Context, id uint, updatedAt *time.Time) (*Player, error) {
+func (s *Service) Checkin(ctx context.Context, id uint, version etag.Versions, checkin *CheckinDto) (*Player, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
 		return nil, err
 	}
+	if err := checkin.Validate(); err != nil {
+		return nil, err
+	}
 
 	var player *Player
 
@@ -154,7 +216,7 @@ func (s *Service) Checkin(ctx context.Context, id uint, updatedAt *time.Time) (*
 			return err
 		}
 
-		p, err := repo.Checkin(ctx, id, updatedAt)
+		p, err := repo.Checkin(ctx, id, version, checkin)
 		if err != nil {
 			return err
 		}
@@ -166,9 +228,56 @@ func (s *Service) Checkin(ctx context.Context, id uint, updatedAt *time.Time) (*
 		return nil, err
 	}
 
+	s.publish(ctx, EventCheckedIn, player)
+
 	return player, nil
 }
 
+func (s *Service) FindCheckins(ctx context.Context, playerId uint, p paging.Paging, q query.Query) (*paging.Page[Checkin], error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var page paging.Page[Checkin]
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if _, err = repo.FindById(ctx, playerId); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		checkins, err := repo.FindCheckins(ctx, playerId, p, q)
+		if err != nil {
+			return err
+		}
+		page = paging.NewPage(checkins, p, func(c Checkin) paging.Cursor {
+			return checkinSchema.Cursor(q, c)
+		})
+
+		if p.Total {
+			total, err := repo.CountCheckins(ctx, playerId, q)
+			if err != nil {
+				return err
+			}
+			page.Total = &total
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
 func (s *Service) Update(ctx context.Context, dto *UpdateDto) error {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
 		return err
@@ -177,47 +286,103 @@ func (s *Service) Update(ctx context.Context, dto *UpdateDto) error {
 		return err
 	}
 
+	var updated *Player
+
 	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
 		repo, err := s.createPlayerRepo(tx)
 		if err != nil {
 			return err
 		}
 
-		err = repo.Update(ctx, dto)
-		if err != nil {
-			return err
-		}
-		return nil
+		updated, err = repo.Update(ctx, dto)
+		return err
 	})
 
 	if err != nil {
 		return err
 	}
 
+	s.publish(ctx, EventUpdated, updated)
+
 	return nil
 }
-func (s *Service) Delete(ctx context.Context, id uint, updatedAt *time.Time) error {
+
+// Patch loads the player, lets apply change it, validates it and saves it with the usual updated_at check.
+func (s *Service) Patch(ctx context.Context, id uint, version etag.Versions, apply func(*Player) error) (*Player, error) {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
-		return err
+		return nil, err
+	}
+	if err := validate.Get().Var(apply, "required"); err != nil {
+		return nil, err
 	}
 
+	var player *Player
+
 	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
 		repo, err := s.createPlayerRepo(tx)
 		if err != nil {
 			return err
 		}
 
-		err = repo.Delete(ctx, id, updatedAt)
+		p, err := repo.FindById(ctx, id)
 		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
 			return err
 		}
-		return nil
+
+		if err = apply(p); err != nil {
+			return err
+		}
+		if err = p.Validate(); err != nil {
+			return err
+		}
+
+		player, err = repo.Update(ctx, &UpdateDto{
+			Id:          p.Id,
+			Name:        p.Name,
+			Description: p.Description,
+			Version:     version,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflict
+		}
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, EventUpdated, player)
+
+	return player, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id uint, version etag.Versions) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	var deleted *Player
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = repo.Delete(ctx, id, version)
+		return err
 	})
 
 	if err != nil {
 		return err
 	}
 
+	s.publish(ctx, EventDeleted, deleted)
+
 	return nil
 }
 
@@ -233,3 +398,62 @@ func (s *Service) createPlayerRepo(tx *sqlx.Tx) (*Repo, error) {
 
 	return repo, nil
 }
+
+func (s *Service) Restore(ctx context.Context, id uint, version etag.Versions) (*Player, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var player *Player
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.Restore(ctx, id, version); err != nil {
+			return err
+		}
+
+		player, err = repo.FindById(ctx, id)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, EventUpdated, player)
+
+	return player, nil
+}
+
+// Purge anonymises or removes the players that have been in the trash since before deletedBefore
+func (s *Service) Purge(ctx context.Context, deletedBefore time.Time, anonymise bool) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	var affected int64
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := s.createPlayerRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if anonymise {
+			affected, err = repo.Anonymise(ctx, deletedBefore)
+		} else {
+			affected, err = repo.Purge(ctx, deletedBefore)
+		}
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return affected, nil
+}
@@ -0,0 +1,180 @@
+package security
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"Go-lab/internal/utils/problem"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	defaultOverlap = 24 * time.Hour
+	maxOverlap     = 30 * 24 * time.Hour
+)
+
+type APIKeyDTO struct {
+	Id         *uint      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	OwnerId    int        `json:"owner_id"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *uint      `json:"replaced_by"`
+	CreatedAt  *time.Time `json:"created_at"`
+	CreatedBy  *int       `json:"created_by"`
+	Key        string     `json:"key,omitempty"` // only in the answer to create and rotate, keep it safe
+}
+
+// APIKeyRequest is the body of POST /security/apikey
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	OwnerId   int        `json:"owner_id"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func ToAPIKeyDTO(k *APIKey) *APIKeyDTO {
+	return &APIKeyDTO{
+		Id:         k.Id,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		OwnerId:    k.OwnerId,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		ReplacedBy: k.ReplacedBy,
+		CreatedAt:  k.CreatedAt,
+		CreatedBy:  k.CreatedBy,
+	}
+}
+
+func (h *Handler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.TimeoutInSeconds)
+	defer cancel()
+
+	keys, err := h.service.FindAPIKeys(ctx)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dtos := make([]APIKeyDTO, len(keys))
+	for i := range keys {
+		dtos[i] = *ToAPIKeyDTO(&keys[i])
+	}
+
+	writeJSON(w, http.StatusOK, dtos)
+}
+
+// CreateKey answers with the key itself, which is not shown again
+func (h *Handler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.TimeoutInSeconds)
+	defer cancel()
+
+	var body APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "bad JSON format")
+		return
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "expires_at is in the past")
+		return
+	}
+
+	created, plain, err := h.service.CreateAPIKey(ctx, &APIKey{
+		Name:      body.Name,
+		OwnerId:   body.OwnerId,
+		Scopes:    body.Scopes,
+		ExpiresAt: body.ExpiresAt,
+	})
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dto := ToAPIKeyDTO(created)
+	dto.Key = plain
+
+	w.Header().Set(headers.CacheControl, "no-store")
+	writeJSON(w, http.StatusCreated, dto)
+}
+
+// RotateKey answers with the successor. ?overlap=12h is how long the old key keeps working, a day by default.
+func (h *Handler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	overlap := defaultOverlap
+	if v := r.URL.Query().Get("overlap"); v != "" {
+		if overlap, err = time.ParseDuration(v); err != nil || overlap < 0 || overlap > maxOverlap {
+			problem.WriteStatus(w, r, http.StatusBadRequest, "overlap must be a duration between 0s and 720h")
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.TimeoutInSeconds)
+	defer cancel()
+
+	created, plain, err := h.service.RotateAPIKey(ctx, id, overlap)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dto := ToAPIKeyDTO(created)
+	dto.Key = plain
+
+	w.Header().Set(headers.CacheControl, "no-store")
+	writeJSON(w, http.StatusCreated, dto)
+}
+
+func (h *Handler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.TimeoutInSeconds)
+	defer cancel()
+
+	if err = h.service.RevokeAPIKey(ctx, id); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func pathParamId(w http.ResponseWriter, r *http.Request, name string) (uint, error) {
+	id, err := strconv.ParseUint(chi.URLParam(r, name), 10, 32)
+	if err != nil {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "invalid "+name)
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
+	w.WriteHeader(status)
+
+	if v == nil {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
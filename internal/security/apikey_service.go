@@ -0,0 +1,190 @@
+package security
+
+import (
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CreateAPIKey stores the key and returns it with the plain key, which is not available again
+func (s *Service) CreateAPIKey(ctx context.Context, key *APIKey) (*APIKey, string, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, "", err
+	}
+	if err := validate.Get().Var(key, "required"); err != nil {
+		return nil, "", err
+	}
+
+	plain, err := newAPIKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var created *APIKey
+
+	err = s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		id, err := repo.CreateAPIKey(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		created, err = repo.FindAPIKey(ctx, *id)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return created, plain, nil
+}
+
+func (s *Service) FindAPIKeys(ctx context.Context) ([]APIKey, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		keys, err = repo.FindAPIKeys(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RotateAPIKey issues a successor with the same name, owner, scopes and expiry. The old key keeps working
+// for the overlap, time enough to roll the new one out to the kiosks.
+func (s *Service) RotateAPIKey(ctx context.Context, id uint, overlap time.Duration) (*APIKey, string, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, "", err
+	}
+
+	var created *APIKey
+	var plain string
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		current, err := repo.FindAPIKey(ctx, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrAPIKeyNotFound
+			}
+			return err
+		}
+		if !current.Usable(time.Now()) || current.ReplacedBy != nil {
+			return ErrAPIKeyRevoked
+		}
+
+		successor := &APIKey{
+			Name:      current.Name,
+			OwnerId:   current.OwnerId,
+			Scopes:    current.Scopes,
+			ExpiresAt: current.ExpiresAt,
+		}
+		if plain, err = newAPIKey(successor); err != nil {
+			return err
+		}
+
+		newId, err := repo.CreateAPIKey(ctx, successor)
+		if err != nil {
+			return err
+		}
+		if err = repo.RetireAPIKey(ctx, id, *newId, time.Now().Add(overlap)); err != nil {
+			return err
+		}
+
+		created, err = repo.FindAPIKey(ctx, *newId)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return created, plain, nil
+}
+
+// RevokeAPIKey stops the key at once, revoking it twice is fine
+func (s *Service) RevokeAPIKey(ctx context.Context, id uint) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	return s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.RevokeAPIKey(ctx, id); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if _, err = repo.FindAPIKey(ctx, id); errors.Is(err, sql.ErrNoRows) {
+				return ErrAPIKeyNotFound
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// authenticateAPIKey returns the key behind plain if it can be used, and records the use
+func (s *Service) authenticateAPIKey(ctx context.Context, plain string) (*APIKey, error) {
+	prefix, ok := apiKeyLookup(plain)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+
+	// nobody is authenticated yet, the lookup runs as the system
+	ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+	var key *APIKey
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		key, err = repo.FindAPIKeyByPrefix(ctx, prefix)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return errInvalidAPIKey
+			}
+			return err
+		}
+		if !key.matches(plain) || !key.Usable(time.Now()) {
+			return errInvalidAPIKey
+		}
+
+		return repo.TouchAPIKey(ctx, *key.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
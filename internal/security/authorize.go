@@ -0,0 +1,156 @@
+package security
+
+import (
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-http-utils/headers"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ScopeRead  = "api:read"
+	ScopeWrite = "api:write"
+	ScopeAdmin = "admin"
+)
+
+// roles change rarely, a role granted or taken away shows up after that
+const rolesTTL = time.Minute
+
+// Authorizer checks scopes on two levels: the token has to carry the scope, and one of the roles of its
+// subject (admin, operator, viewer, see security_role) has to grant it. A client can so never do more than
+// the user it acts as, nor more than it asked for.
+type Authorizer struct {
+	lookup func(ctx context.Context, userId int) (Scopes, error)
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[int]cachedScopes
+}
+
+type cachedScopes struct {
+	scopes Scopes
+	at     time.Time
+}
+
+func NewAuthorizer(dbUtils *dbutils.DbUtils) *Authorizer {
+	if err := validate.Get().Var(dbUtils, "required"); err != nil {
+		panic(err)
+	}
+
+	return newAuthorizer(func(ctx context.Context, userId int) (Scopes, error) {
+		var scopes Scopes
+
+		err := dbUtils.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+			repo, err := NewRepo(tx)
+			if err != nil {
+				return err
+			}
+
+			scopes, err = repo.FindUserScopes(ctx, userId)
+			return err
+		})
+
+		return scopes, err
+	}, rolesTTL)
+}
+
+func newAuthorizer(lookup func(ctx context.Context, userId int) (Scopes, error), ttl time.Duration) *Authorizer {
+	return &Authorizer{
+		lookup: lookup,
+		ttl:    ttl,
+		cache:  map[int]cachedScopes{},
+	}
+}
+
+// RequireScope lets the request through when both the token and the roles of the user grant the scope.
+// It goes behind Authenticate.
+func (a *Authorizer) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			userId, found := session.UserIDFromContext(r.Context())
+			if !ok || !found {
+				challenge(w, r, "", "")
+				return
+			}
+
+			if !ParseScopes(claims.Scope).Covers(Scopes{scope}) {
+				a.deny(r, userId, claims, scope, "token")
+				w.Header().Set(headers.WWWAuthenticate,
+					`Bearer realm="`+realm+`", error="insufficient_scope", scope="`+scope+`"`)
+				problem.WriteStatus(w, r, http.StatusForbidden, "the access token does not have the "+scope+" scope")
+				return
+			}
+
+			granted, err := a.userScopes(r.Context(), userId)
+			if err != nil {
+				problem.Write(w, r, err)
+				return
+			}
+			if !granted.Covers(Scopes{scope}) {
+				a.deny(r, userId, claims, scope, "role")
+				problem.WriteStatus(w, r, http.StatusForbidden, "no role of the user grants "+scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Has tells whether both the token and the roles of the user grant the scope, for a handler that only
+// widens what it shows to some users. Without a token nothing is granted.
+func (a *Authorizer) Has(ctx context.Context, scope string) (bool, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	userId, found := session.UserIDFromContext(ctx)
+	if !ok || !found || !ParseScopes(claims.Scope).Covers(Scopes{scope}) {
+		return false, nil
+	}
+
+	granted, err := a.userScopes(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	return granted.Covers(Scopes{scope}), nil
+}
+
+func (a *Authorizer) deny(r *http.Request, userId int, claims *Claims, scope, missingIn string) {
+	slog.WarnContext(r.Context(), "access denied",
+		"user_id", userId,
+		"client_id", claims.ClientId,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"scope", scope,
+		"missing_in", missingIn,
+		"request_id", middleware.GetReqID(r.Context()),
+	)
+}
+
+func (a *Authorizer) userScopes(ctx context.Context, userId int) (Scopes, error) {
+	a.mu.Lock()
+	cached, ok := a.cache[userId]
+	a.mu.Unlock()
+	if ok && time.Since(cached.at) < a.ttl {
+		return cached.scopes, nil
+	}
+
+	scopes, err := a.lookup(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.cache[userId] = cachedScopes{scopes: scopes, at: time.Now()}
+	a.mu.Unlock()
+
+	return scopes, nil
+}
@@ -0,0 +1,156 @@
+package security
+
+import (
+	"Go-lab/internal/middleware/ratelimit"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/session"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-http-utils/headers"
+)
+
+// leeway absorbs the clock skew between the issuer and us
+const leeway = 30 * time.Second
+
+var (
+	errTokenExpired = fmt.Errorf("%w: expired", ErrToken)
+	errTokenRevoked = fmt.Errorf("%w: revoked", ErrToken)
+)
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the bearer token the request was authenticated with
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// VerifyToken checks an access token issued by this service: signature, exp, nbf, iss and aud.
+// The subject has to be a user id.
+func (s *Service) VerifyToken(token string) (*Claims, error) {
+	claims, err := ParseToken(token, s.keys.Lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)):
+		return nil, errTokenExpired
+	case claims.NotBefore != 0 && now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrToken)
+	case claims.Issuer != s.issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrToken, claims.Issuer)
+	case !claims.Audience.Contains(s.cfg.Audience):
+		return nil, fmt.Errorf("%w: audience %v", ErrToken, claims.Audience)
+	}
+	if _, err = strconv.Atoi(claims.Subject); err != nil {
+		return nil, fmt.Errorf("%w: subject %q", ErrToken, claims.Subject)
+	}
+
+	return claims, nil
+}
+
+// Authenticate lets only requests with a valid bearer token or API key through, RFC 6750. They run as the
+// subject of the token or the owner of the key, which is what ends up in @session_user_id and the audit columns.
+func (s *Service) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if plain := r.Header.Get(headerAPIKey); plain != "" {
+			s.authenticateKey(w, r, plain, next)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			challenge(w, r, "", "")
+			return
+		}
+
+		claims, err := s.verifyActive(r.Context(), token)
+		if err != nil {
+			if !errors.Is(err, ErrToken) {
+				problem.Write(w, r, err)
+				return
+			}
+			slog.InfoContext(r.Context(), "bearer token rejected", "path", r.URL.Path, "request_id", middleware.GetReqID(r.Context()), "error", err)
+			description := "the access token is invalid"
+			switch {
+			case errors.Is(err, errTokenExpired):
+				description = "the access token expired"
+			case errors.Is(err, errTokenRevoked):
+				description = "the access token was revoked"
+			}
+			challenge(w, r, "invalid_token", description)
+			return
+		}
+
+		userId, _ := strconv.Atoi(claims.Subject)
+		ctx := session.ContextWithUserID(r.Context(), userId)
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateKey runs the request as the owner of the key, with claims standing in for a token
+func (s *Service) authenticateKey(w http.ResponseWriter, r *http.Request, plain string, next http.Handler) {
+	key, err := s.authenticateAPIKey(r.Context(), plain)
+	if err != nil {
+		if !errors.Is(err, errInvalidAPIKey) {
+			problem.Write(w, r, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "api key rejected", "path", r.URL.Path, "request_id", middleware.GetReqID(r.Context()))
+		challenge(w, r, "invalid_token", "the API key is invalid")
+		return
+	}
+
+	claims := &Claims{
+		Subject:  strconv.Itoa(key.OwnerId),
+		ClientId: "apikey:" + key.Prefix,
+		Scope:    key.Scopes.String(),
+	}
+	ctx := session.ContextWithUserID(r.Context(), key.OwnerId)
+	ctx = context.WithValue(ctx, claimsKey{}, claims)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get(headers.Authorization), " ")
+	if !ok || !strings.EqualFold(scheme, tokenTypeBearer) {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// challenge answers 401 with the WWW-Authenticate of RFC 6750 section 3, code is empty when there was no token
+func challenge(w http.ResponseWriter, r *http.Request, code, description string) {
+	value := `Bearer realm="` + realm + `"`
+	if code != "" {
+		value += `, error="` + code + `", error_description="` + description + `"`
+	}
+	w.Header().Set(headers.WWWAuthenticate, value)
+
+	if description == "" {
+		description = "a bearer token is required"
+	}
+	problem.WriteStatus(w, r, http.StatusUnauthorized, description)
+}
+
+// RateLimitKey counts the requests of a token per client and those of an API key per key, anything else per IP
+func RateLimitKey(r *http.Request) string {
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		return "client:" + claims.ClientId
+	}
+	return ratelimit.ByIP(r)
+}
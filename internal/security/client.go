@@ -0,0 +1,85 @@
+package security
+
+import (
+	"Go-lab/internal/audit"
+	"Go-lab/internal/utils"
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Client is an OAuth client allowed to use the client-credentials grant. Its tokens act as UserId,
+// which is what the audit columns record.
+type Client struct {
+	audit.Auditable
+	Id         string `db:"client_id"`
+	SecretHash string `db:"secret_hash"`
+	Name       string `db:"name"`
+	UserId     int    `db:"user_id"`
+	Scopes     Scopes `db:"scopes"`
+	Active     bool   `db:"active"`
+}
+
+func (c *Client) String() string {
+	cc := *c
+	cc.SecretHash = "***"
+	return utils.ToString(cc)
+}
+
+// Scopes is space separated in the database and on the wire, like the scope parameter of RFC 6749
+type Scopes []string
+
+func ParseScopes(s string) Scopes {
+	return strings.Fields(s)
+}
+
+func (s Scopes) String() string {
+	return strings.Join(s, " ")
+}
+
+// Covers tells whether every one of the wanted scopes is granted
+func (s Scopes) Covers(wanted Scopes) bool {
+	for _, w := range wanted {
+		if !slices.Contains(s, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Scopes) Value() (driver.Value, error) {
+	return s.String(), nil
+}
+
+func (s *Scopes) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s = nil
+	case string:
+		*s = ParseScopes(v)
+	case []byte:
+		*s = ParseScopes(string(v))
+	default:
+		return fmt.Errorf("cannot scan %T into scopes", src)
+	}
+	return nil
+}
+
+// HashSecret is how client secrets are stored
+func HashSecret(secret string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// dummyHash is checked against when the client does not exist, so that unknown ids take as long as bad secrets
+var dummyHash, _ = HashSecret("not a secret")
+
+func checkSecret(hash, secret string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+}
@@ -0,0 +1,59 @@
+package security
+
+import (
+	"Go-lab/internal/utils/problem"
+	"net/http"
+	"strings"
+)
+
+// Discovery is the authorization server metadata, RFC 8414, which OpenID Connect discovery shares
+type Discovery struct {
+	Issuer                            string   `json:"issuer"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	AccessTokenSigningAlgs            []string `json:"access_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	IntrospectionEndpointAuthMethods  []string `json:"introspection_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethods     []string `json:"revocation_endpoint_auth_methods_supported"`
+}
+
+var clientAuthMethods = []string{"client_secret_basic", "client_secret_post"}
+
+// Discovery returns the metadata for the issuer, the endpoints are the ones mounted under /security
+func (s *Service) Discovery() *Discovery {
+	base := strings.TrimSuffix(s.issuer, "/")
+
+	return &Discovery{
+		Issuer:                            s.issuer,
+		TokenEndpoint:                     base + "/security/oauth/token",
+		JwksUri:                           base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             base + "/security/oauth/introspect",
+		RevocationEndpoint:                base + "/security/oauth/revoke",
+		GrantTypesSupported:               []string{grantClientCredentials},
+		TokenEndpointAuthMethodsSupported: clientAuthMethods,
+		AccessTokenSigningAlgs:            s.keys.Algs(),
+		ScopesSupported:                   []string{ScopeRead, ScopeWrite, ScopeAdmin},
+		IntrospectionEndpointAuthMethods:  clientAuthMethods,
+		RevocationEndpointAuthMethods:     clientAuthMethods,
+	}
+}
+
+// JWKS publishes the public keys the access tokens can be verified with
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	set, err := h.service.keys.JWKS()
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, set)
+}
+
+// Discovery serves /.well-known/openid-configuration and /.well-known/oauth-authorization-server
+func (h *Handler) Discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.service.Discovery())
+}
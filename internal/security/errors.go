@@ -0,0 +1,75 @@
+package security
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-http-utils/headers"
+)
+
+const realm = "golab"
+
+// Error is an RFC 6749 section 5.2 error response. The token endpoint answers with these rather than
+// problem details, OAuth clients only understand this shape.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func newError(status int, code, description string) *Error {
+	return &Error{Code: code, Description: description, status: status}
+}
+
+// the sentinels carry no description, compare with errors.Is
+var (
+	ErrInvalidRequest       = newError(http.StatusBadRequest, "invalid_request", "")
+	ErrInvalidClient        = newError(http.StatusUnauthorized, "invalid_client", "")
+	ErrUnsupportedGrantType = newError(http.StatusBadRequest, "unsupported_grant_type", "")
+	ErrInvalidScope         = newError(http.StatusBadRequest, "invalid_scope", "")
+)
+
+func invalidRequest(description string) *Error {
+	return newError(ErrInvalidRequest.status, ErrInvalidRequest.Code, description)
+}
+
+func invalidClient(description string) *Error {
+	return newError(ErrInvalidClient.status, ErrInvalidClient.Code, description)
+}
+
+func invalidScope(description string) *Error {
+	return newError(ErrInvalidScope.status, ErrInvalidScope.Code, description)
+}
+
+// writeError writes err as an RFC 6749 error, anything that is not an *Error is a server_error
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) {
+		slog.ErrorContext(r.Context(), "oauth", "path", r.URL.Path, "error", err)
+		oauthErr = newError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	if oauthErr.status == http.StatusUnauthorized {
+		w.Header().Set(headers.WWWAuthenticate, `Basic realm="`+realm+`"`)
+	}
+	w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
+	w.Header().Set(headers.CacheControl, "no-store")
+	w.Header().Set(headers.Pragma, "no-cache")
+	w.WriteHeader(oauthErr.status)
+	_ = json.NewEncoder(w).Encode(oauthErr)
+}
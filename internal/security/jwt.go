@@ -0,0 +1,131 @@
+package security
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const typAccessJwt = "at+jwt" // RFC 9068
+
+var ErrToken = errors.New("invalid token")
+
+var b64 = base64.RawURLEncoding
+
+// Claims are the registered claims of RFC 7519 and the ones RFC 9068 adds for access tokens
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	Id        string   `json:"jti"`
+	ClientId  string   `json:"client_id"`
+	Scope     string   `json:"scope,omitempty"`
+}
+
+// Audience is a single string or an array in the JWT, always a slice here
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = l
+	return nil
+}
+
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// SignToken returns the claims as a compact JWS signed with the key
+func SignToken(claims *Claims, key *SigningKey) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: key.Alg, Typ: typAccessJwt, Kid: key.Id})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	signature, err := key.sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + b64.EncodeToString(signature), nil
+}
+
+// ParseToken checks the signature with the key the kid names and returns the claims. It does not look at
+// the claims themselves, expiry and audience are up to the caller.
+func ParseToken(token string, keyFor func(kid string) (*PublicKey, error)) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a compact JWS", ErrToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrToken, err)
+	}
+
+	key, err := keyFor(header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrToken, err)
+	}
+	// the algorithm belongs to the key, the token only has to agree
+	if header.Alg != key.Alg {
+		return nil, fmt.Errorf("%w: unexpected alg %q", ErrToken, header.Alg)
+	}
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrToken, err)
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, fmt.Errorf("%w: bad signature", ErrToken)
+	}
+
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrToken, err)
+	}
+	return &claims, nil
+}
+
+func decodeSegment(s string, v any) error {
+	b, err := b64.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
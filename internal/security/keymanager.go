@@ -0,0 +1,251 @@
+package security
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var errNoSigningKey = errors.New("no signing key is active")
+
+// KeyManager keeps the signing keys in oauth_signing_key, with the private keys encrypted. A successor is
+// made when the newest key is RotateEvery old. It activates two checks later, so every instance publishes
+// it before the first token is signed with it. A key stays published until the tokens it signed expired.
+type KeyManager struct {
+	db       *dbutils.DbUtils
+	cfg      config.KeyConfig
+	tokenTTL time.Duration
+	kek      []byte
+
+	mu   sync.RWMutex
+	keys []*SigningKey // by ActivatesAt
+}
+
+func NewKeyManager(dbUtils *dbutils.DbUtils, cfg config.AuthConfig, dev bool) (*KeyManager, error) {
+	if err := validate.Get().Var(dbUtils, "required"); err != nil {
+		return nil, err
+	}
+	if cfg.Keys.Alg != AlgEdDSA && cfg.Keys.Alg != AlgRS256 {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", cfg.Keys.Alg)
+	}
+
+	kek, err := encryptionKey(cfg.Keys.EncryptionKey, dev)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyManager{
+		db:       dbUtils,
+		cfg:      cfg.Keys,
+		tokenTTL: cfg.TokenTTL,
+		kek:      kek,
+	}, nil
+}
+
+// encryptionKey decodes the base64 AES-256 key. Only dev may go without one, its keys do not outlive a restart.
+func encryptionKey(encoded string, dev bool) ([]byte, error) {
+	if encoded == "" {
+		if !dev {
+			return nil, errors.New("the key encryption key is missing")
+		}
+		slog.Warn("oauth: no key encryption key configured, the signing keys cannot be read after a restart")
+
+		kek := make([]byte, 32)
+		if _, err := rand.Read(kek); err != nil {
+			return nil, err
+		}
+		return kek, nil
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key encryption key: %w", err)
+	}
+	if len(kek) != 32 {
+		return nil, fmt.Errorf("key encryption key: want 32 bytes, got %d", len(kek))
+	}
+	return kek, nil
+}
+
+// Current is the key new tokens are signed with, the newest one that is active
+func (m *KeyManager) Current() (*SigningKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if key := current(m.keys, time.Now()); key != nil {
+		return key, nil
+	}
+	return nil, errNoSigningKey
+}
+
+// Lookup is the public key for the kid of a token, see ParseToken
+func (m *KeyManager) Lookup(kid string) (*PublicKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.Id == kid {
+			return key.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+// JWKSet is the RFC 7517 section 5 document
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the keys a token may be verified with: the coming ones, the current one and the retired ones
+// whose tokens are still valid
+func (m *KeyManager) JWKS() (*JWKSet, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := &JWKSet{Keys: make([]JWK, 0, len(m.keys))}
+	for _, key := range m.published(time.Now()) {
+		jwk, err := key.Public().JWK()
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, *jwk)
+	}
+	return set, nil
+}
+
+// Algs are the algorithms of the keys, for the discovery document
+func (m *KeyManager) Algs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	algs := []string{m.cfg.Alg}
+	for _, key := range m.keys {
+		if !slices.Contains(algs, key.Alg) {
+			algs = append(algs, key.Alg)
+		}
+	}
+	return algs
+}
+
+// Refresh loads the keys, makes a successor when the newest one is due and removes the keys nobody needs
+// any longer. The rows stay locked meanwhile, so instances do not rotate at the same time.
+func (m *KeyManager) Refresh(ctx context.Context) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var keys []*SigningKey
+
+	err := m.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		stored, err := repo.LockSigningKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		keys = make([]*SigningKey, 0, len(stored)+1)
+		for _, s := range stored {
+			private, err := openKey(m.kek, s.PrivateKey)
+			if err != nil {
+				slog.WarnContext(ctx, "oauth: cannot decrypt signing key, skipped", "kid", s.Id, "error", err)
+				continue
+			}
+			keys = append(keys, &SigningKey{Id: s.Id, Alg: s.Alg, Private: private, ActivatesAt: s.ActivatesAt})
+		}
+
+		var activatesAt time.Time
+		switch {
+		case current(keys, now) == nil:
+			// nothing to sign with, the first start or the encryption key changed
+			activatesAt = now
+		case now.Sub(keys[len(keys)-1].ActivatesAt) >= m.cfg.RotateEvery:
+			activatesAt = now.Add(2 * m.cfg.CheckEvery)
+		default:
+			return m.prune(ctx, repo, now)
+		}
+
+		key, err := GenerateSigningKey(m.cfg.Alg, activatesAt)
+		if err != nil {
+			return err
+		}
+		sealed, err := sealKey(m.kek, key.Private)
+		if err != nil {
+			return err
+		}
+		if err = repo.CreateSigningKey(ctx, key.Id, key.Alg, sealed, key.ActivatesAt); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		slog.InfoContext(ctx, "oauth: signing key created", "kid", key.Id, "alg", key.Alg, "activates_at", key.ActivatesAt)
+
+		return m.prune(ctx, repo, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = keys
+	m.keys = m.published(now)
+	return nil
+}
+
+func (m *KeyManager) prune(ctx context.Context, repo *Repo, now time.Time) error {
+	pruned, err := repo.PruneSigningKeys(ctx, now.Add(-m.tokenTTL-leeway))
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		slog.InfoContext(ctx, "oauth: retired signing keys removed", "removed", pruned)
+	}
+	return nil
+}
+
+// published drops the keys whose successor has been active longer than a token lives
+func (m *KeyManager) published(now time.Time) []*SigningKey {
+	keys := make([]*SigningKey, 0, len(m.keys))
+	for i, key := range m.keys {
+		if i+1 < len(m.keys) && now.After(m.keys[i+1].ActivatesAt.Add(m.tokenTTL+leeway)) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// current is the newest of the keys, sorted by ActivatesAt, that is active at now
+func current(keys []*SigningKey, now time.Time) *SigningKey {
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !keys[i].ActivatesAt.After(now) {
+			return keys[i]
+		}
+	}
+	return nil
+}
+
+// NewKeyRotationService refreshes the signing keys on a schedule
+func NewKeyRotationService(m *KeyManager) utils.Service {
+	return utils.NewScheduledService("oauth-key-rotation", m.cfg.CheckEvery, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+		return m.Refresh(ctx)
+	})
+}
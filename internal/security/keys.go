@@ -0,0 +1,188 @@
+package security
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+
+	rsaBits = 2048
+)
+
+// SigningKey signs the access tokens, Id goes into the kid header. Tokens are signed with the newest key
+// whose ActivatesAt has passed.
+type SigningKey struct {
+	Id          string
+	Alg         string
+	Private     crypto.Signer
+	ActivatesAt time.Time
+}
+
+// PublicKey verifies the tokens of a SigningKey, the algorithm comes with the key and never from the token
+type PublicKey struct {
+	Id  string
+	Alg string
+	Key crypto.PublicKey
+}
+
+func (k *SigningKey) Public() *PublicKey {
+	return &PublicKey{Id: k.Id, Alg: k.Alg, Key: k.Private.Public()}
+}
+
+// GenerateSigningKey makes a key for the algorithm, EdDSA (Ed25519) or RS256
+func GenerateSigningKey(alg string, activatesAt time.Time) (*SigningKey, error) {
+	var private crypto.Signer
+	var err error
+
+	switch alg {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaBits)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newSigningKey(alg, private, activatesAt)
+}
+
+func newSigningKey(alg string, private crypto.Signer, activatesAt time.Time) (*SigningKey, error) {
+	k := &SigningKey{Alg: alg, Private: private, ActivatesAt: activatesAt}
+	jwk, err := k.Public().JWK()
+	if err != nil {
+		return nil, err
+	}
+	k.Id = jwk.thumbprint()
+	return k, nil
+}
+
+func (k *SigningKey) sign(input []byte) ([]byte, error) {
+	switch k.Alg {
+	case AlgEdDSA:
+		return k.Private.Sign(rand.Reader, input, crypto.Hash(0))
+	case AlgRS256:
+		sum := sha256.Sum256(input)
+		return k.Private.Sign(rand.Reader, sum[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", k.Alg)
+	}
+}
+
+func (k *PublicKey) verify(input, signature []byte) bool {
+	switch key := k.Key.(type) {
+	case ed25519.PublicKey:
+		return k.Alg == AlgEdDSA && ed25519.Verify(key, input, signature)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(input)
+		return k.Alg == AlgRS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+// JWK is a public key as RFC 7517 and RFC 8037 write it
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+func (k *PublicKey) JWK() (*JWK, error) {
+	jwk := &JWK{Kid: k.Id, Alg: k.Alg, Use: "sig"}
+
+	switch key := k.Key.(type) {
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", b64.EncodeToString(key)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64.EncodeToString(key.N.Bytes())
+		jwk.E = b64.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", k.Key)
+	}
+	return jwk, nil
+}
+
+// thumbprint is the RFC 7638 thumbprint: the required members in lexicographic order, no whitespace
+func (j *JWK) thumbprint() string {
+	var canonical string
+	switch j.Kty {
+	case "OKP":
+		canonical = `{"crv":"` + j.Crv + `","kty":"OKP","x":"` + j.X + `"}`
+	case "RSA":
+		canonical = `{"e":"` + j.E + `","kty":"RSA","n":"` + j.N + `"}`
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64.EncodeToString(sum[:])
+}
+
+// sealKey encrypts the PKCS #8 form of the private key with AES-256-GCM, the nonce goes first
+func sealKey(kek []byte, private crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, der, nil), nil
+}
+
+func openKey(kek, sealed []byte) (crypto.Signer, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed key too short")
+	}
+
+	der, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+func newGCM(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -4,29 +4,42 @@ import (
 	"Go-lab/config"
 	"Go-lab/internal/utils/httpconst"
 	"context"
-	"log"
+	"encoding/json"
+	"log/slog"
+	"mime"
 	"net/http"
+	"net/url"
 
 	"github.com/go-http-utils/headers"
 )
 
+const (
+	grantClientCredentials = "client_credentials"
+	formUrlEncoded         = "application/x-www-form-urlencoded"
+	maxTokenRequest        = 16 << 10
+)
+
 type Handler struct {
-	config config.AppConfig
-	ctx    context.Context
+	config  config.AppConfig
+	auth    config.AuthConfig
+	service *Service
+	ctx     context.Context
 }
 
-func NewHandler(ctx context.Context, config config.AppConfig) *Handler {
+func NewHandler(ctx context.Context, config config.AppConfig, auth config.AuthConfig, service *Service) *Handler {
 	return &Handler{
-		config: config,
-		ctx:    ctx,
+		config:  config,
+		auth:    auth,
+		service: service,
+		ctx:     ctx,
 	}
 }
 
-// Auth writes a fake access token
-func (h *Handler) Auth(w http.ResponseWriter, _ *http.Request) {
-	// Fast path for dev
-	if h.config.IsDev() {
-		log.Println("Auth Test")
+// Auth is the token endpoint, RFC 6749 section 3.2. Only the client-credentials grant is supported.
+func (h *Handler) Auth(w http.ResponseWriter, r *http.Request) {
+	// the fake token is opt-in, and never outside dev
+	if h.config.IsDev() && h.auth.FakeToken {
+		slog.WarnContext(r.Context(), "oauth: handing out the fake dev token")
 
 		w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
 		w.WriteHeader(http.StatusOK) // explicit status
@@ -39,5 +52,74 @@ func (h *Handler) Auth(w http.ResponseWriter, _ *http.Request) {
 		return
 	}
 
-	log.Println("Auth for real")
+	if err := parseForm(w, r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	switch grantType := r.PostForm.Get("grant_type"); grantType {
+	case grantClientCredentials:
+	case "":
+		writeError(w, r, invalidRequest("grant_type is missing"))
+		return
+	default:
+		writeError(w, r, ErrUnsupportedGrantType)
+		return
+	}
+
+	clientId, secret, err := clientCredentials(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	token, err := h.service.ClientCredentials(r.Context(), clientId, secret, r.PostForm.Get("scope"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
+	w.Header().Set(headers.CacheControl, "no-store")
+	w.Header().Set(headers.Pragma, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(token)
+}
+
+// parseForm reads the form body all the endpoints of RFC 6749 and its extensions take
+func parseForm(w http.ResponseWriter, r *http.Request) error {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get(headers.ContentType)); mediaType != formUrlEncoded {
+		return invalidRequest("the body must be " + formUrlEncoded)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxTokenRequest)
+	if err := r.ParseForm(); err != nil {
+		return invalidRequest("cannot parse the body")
+	}
+	return nil
+}
+
+// clientCredentials takes the client from HTTP Basic or from the body, RFC 6749 section 2.3.1; using both is an error
+func clientCredentials(r *http.Request) (string, string, error) {
+	basicId, basicSecret, basic := r.BasicAuth()
+	bodyId, bodySecret := r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+
+	switch {
+	case basic && (bodyId != "" || bodySecret != ""):
+		return "", "", invalidRequest("more than one client authentication method")
+	case basic:
+		// form-encoded before going into the header
+		id, err := url.QueryUnescape(basicId)
+		if err != nil {
+			return "", "", invalidClient("")
+		}
+		secret, err := url.QueryUnescape(basicSecret)
+		if err != nil {
+			return "", "", invalidClient("")
+		}
+		return id, secret, nil
+	case bodyId != "":
+		return bodyId, bodySecret, nil
+	default:
+		return "", "", invalidClient("client authentication is missing")
+	}
 }
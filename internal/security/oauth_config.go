@@ -1,12 +1,16 @@
 package security
 
 import (
+	"Go-lab/config"
+	"Go-lab/internal/utils/resilience"
 	"context"
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/go-http-utils/headers"
 	"github.com/go-resty/resty/v2"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
@@ -21,35 +25,29 @@ type loggingTransport struct {
 }
 
 func (t loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	fmt.Println(">>>>> Sending:", req.Method, req.URL.String())
-	fmt.Println("Headers:", req.Header)
-	auth := req.Header.Get("Authorization")
-	if auth == "" {
-		fmt.Println("No Authorization header")
-	} else {
-		fmt.Println("Authorization header:", auth)
-	}
+	// the scheme only, the credentials stay out of the logs
+	auth, _, _ := strings.Cut(req.Header.Get(headers.Authorization), " ")
+	slog.DebugContext(req.Context(), "outbound request", "method", req.Method, "url", req.URL.String(), "auth", auth)
 	return t.base.RoundTrip(req)
 }
 
-func NewOAuthConfig(ctx context.Context, baseUrl string) *OAuthConfig {
+func NewOAuthConfig(ctx context.Context, baseUrl string, auth config.AuthConfig) *OAuthConfig {
 	cfg := &clientcredentials.Config{
-		ClientID:     "your-client-id",
-		ClientSecret: "your-client-secret",
+		ClientID:     auth.ClientID,
+		ClientSecret: auth.ClientSecret,
 		TokenURL:     baseUrl + "/security/oauth/token",
-		Scopes:       []string{"api:read", "api:write"},
+		Scopes:       auth.Scopes,
 	}
 
 	c := cfg.Client(ctx)
 
 	logTransport(c)
 
+	// retries live in the resilience transport, which knows which methods are safe to repeat;
+	// the timeout covers all attempts
 	client := resty.NewWithClient(c).
 		SetBaseURL(baseUrl).
-		SetTimeout(5 * time.Second).
-		SetRetryCount(3).
-		SetRetryWaitTime(5 * time.Second).
-		SetRetryMaxWaitTime(5 * time.Second)
+		SetTimeout(30 * time.Second)
 
 	res := &OAuthConfig{
 		Client: client,
@@ -68,6 +66,6 @@ func logTransport(c *http.Client) {
 		base = http.DefaultTransport
 	}
 
-	// Wrap the base in loggingTransport
-	oauthTr.Base = loggingTransport{base: base}
+	// Wrap the base in loggingTransport, and that in the breaker/bulkhead/retries
+	oauthTr.Base = resilience.NewTransport("api", loggingTransport{base: base}, resilience.DefaultOptions())
 }
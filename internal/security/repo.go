@@ -0,0 +1,423 @@
+package security
+
+import (
+	"Go-lab/internal/utils/validate"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Repo struct {
+	tx *sqlx.Tx
+}
+
+func NewRepo(tx *sqlx.Tx) (*Repo, error) {
+	if err := validate.Get().Var(tx, "required"); err != nil {
+		return nil, fmt.Errorf("invalid tx: %w", err)
+	}
+
+	return &Repo{tx: tx}, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindClient(ctx context.Context, id string) (*Client, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var client Client
+
+	if err := r.tx.GetContext(ctx, &client, `
+		SELECT
+			client_id,
+			secret_hash,
+			name,
+			user_id,
+			scopes,
+			active,
+			created_at,
+			created_by,
+			updated_at,
+			updated_by
+		FROM
+			oauth_client
+		WHERE
+			client_id = ?
+		AND
+			deleted_at IS NULL`,
+		id,
+	); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// FindUserScopes returns the scopes the roles of the user grant
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindUserScopes(ctx context.Context, userId int) (Scopes, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+
+	if err := r.tx.SelectContext(ctx, &scopes, `
+		SELECT DISTINCT
+			rs.scope
+		FROM
+			security_user_role ur
+		JOIN
+			security_role_scope rs ON rs.role = ur.role
+		WHERE
+			ur.user_id = ?
+		ORDER BY
+			rs.scope`,
+		userId,
+	); err != nil {
+		return nil, err
+	}
+
+	return scopes, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) CreateAPIKey(ctx context.Context, key *APIKey) (*uint, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+
+	res, err := r.tx.NamedExecContext(ctx, `
+		INSERT INTO api_key
+			(prefix, key_hash, name, owner_id, scopes, expires_at)
+		VALUES
+			(:prefix, :key_hash, :name, :owner_id, :scopes, :expires_at)`,
+		key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert api key: %w", err)
+	}
+
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("insert api key (cannot get lastInsertId): %w", err)
+	}
+
+	id := uint(lastInsertedId)
+
+	return &id, nil
+}
+
+const selectAPIKey = `
+		SELECT
+			id,
+			prefix,
+			key_hash,
+			name,
+			owner_id,
+			scopes,
+			expires_at,
+			last_used_at,
+			revoked_at,
+			replaced_by,
+			created_at,
+			created_by
+		FROM
+			api_key`
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindAPIKey(ctx context.Context, id uint) (*APIKey, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+
+	if err := r.tx.GetContext(ctx, &key, selectAPIKey+`
+		WHERE
+			id = ?`,
+		id,
+	); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+
+	if err := r.tx.GetContext(ctx, &key, selectAPIKey+`
+		WHERE
+			prefix = ?`,
+		prefix,
+	); err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindAPIKeys(ctx context.Context) ([]APIKey, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+
+	if err := r.tx.SelectContext(ctx, &keys, selectAPIKey+`
+		ORDER BY
+			id`,
+	); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RetireAPIKey lets the key live until retireAt at the latest and points it to its replacement
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) RetireAPIKey(ctx context.Context, id, replacedBy uint, retireAt time.Time) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			api_key
+		SET
+			expires_at = LEAST(COALESCE(expires_at, ?), ?),
+			replaced_by = ?
+		WHERE
+			id = ?
+		AND
+			revoked_at IS NULL`,
+		retireAt, retireAt, replacedBy, id,
+	)
+	if err != nil {
+		return fmt.Errorf("retire api key: %w", err)
+	}
+
+	return affectedOne(res, id)
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) RevokeAPIKey(ctx context.Context, id uint) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			api_key
+		SET
+			revoked_at = CURRENT_TIMESTAMP,
+			revoked_by = @session_user_id
+		WHERE
+			id = ?
+		AND
+			revoked_at IS NULL`,
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+
+	return affectedOne(res, id)
+}
+
+// TouchAPIKey records the use of a key, at most once a minute so busy kiosks do not write on every request
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) TouchAPIKey(ctx context.Context, id uint) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	_, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			api_key
+		SET
+			last_used_at = CURRENT_TIMESTAMP
+		WHERE
+			id = ?
+		AND
+			(last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL 1 MINUTE)`,
+		id,
+	)
+	return err
+}
+
+func affectedOne(res sql.Result, id uint) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected check for %d: %w", id, err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RevokeToken adds the jti to the revocation list, revoking twice is fine
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	_, err := r.tx.ExecContext(ctx, `
+		INSERT INTO oauth_revoked_token
+			(jti, expires_at)
+		VALUES
+			(?, ?)
+		ON DUPLICATE KEY UPDATE
+			jti = jti`,
+		jti, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	return nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return false, err
+	}
+
+	var revoked bool
+
+	if err := r.tx.GetContext(ctx, &revoked, `
+		SELECT EXISTS (
+			SELECT
+				1
+			FROM
+				oauth_revoked_token
+			WHERE
+				jti = ?
+		)`,
+		jti,
+	); err != nil {
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) PruneRevokedTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		DELETE FROM
+			oauth_revoked_token
+		WHERE
+			expires_at < ?`,
+		expiredBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("prune revoked tokens: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+// storedKey is a row of oauth_signing_key, PrivateKey is sealed with the key encryption key
+type storedKey struct {
+	Id          string    `db:"kid"`
+	Alg         string    `db:"alg"`
+	PrivateKey  []byte    `db:"private_key"`
+	ActivatesAt time.Time `db:"activates_at"`
+}
+
+// LockSigningKeys returns the signing keys by activation and locks them until the transaction ends
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) LockSigningKeys(ctx context.Context) ([]storedKey, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var keys []storedKey
+
+	if err := r.tx.SelectContext(ctx, &keys, `
+		SELECT
+			kid,
+			alg,
+			private_key,
+			activates_at
+		FROM
+			oauth_signing_key
+		ORDER BY
+			activates_at
+		FOR UPDATE`,
+	); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) CreateSigningKey(ctx context.Context, kid, alg string, sealed []byte, activatesAt time.Time) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	_, err := r.tx.ExecContext(ctx, `
+		INSERT INTO oauth_signing_key
+			(kid, alg, private_key, activates_at)
+		VALUES
+			(?, ?, ?, ?)`,
+		kid, alg, sealed, activatesAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert signing key: %w", err)
+	}
+	return nil
+}
+
+// PruneSigningKeys removes the keys with a successor active since before supersededBefore
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) PruneSigningKeys(ctx context.Context, supersededBefore time.Time) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		DELETE
+			k
+		FROM
+			oauth_signing_key k
+		JOIN
+			oauth_signing_key s ON s.activates_at > k.activates_at
+		WHERE
+			s.activates_at < ?`,
+		supersededBefore,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("prune signing keys: %w", err)
+	}
+
+	return res.RowsAffected()
+}
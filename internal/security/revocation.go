@@ -0,0 +1,247 @@
+package security
+
+import (
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/httpconst"
+	"Go-lab/internal/utils/session"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrUnauthorizedClient = newError(http.StatusBadRequest, "unauthorized_client", "")
+
+// Introspection is the RFC 7662 section 2.2 response, only Active is set for a token that is not
+type Introspection struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientId  string   `json:"client_id,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Id        string   `json:"jti,omitempty"`
+}
+
+// Introspect tells whether the token is one of ours, unexpired and not revoked
+func (s *Service) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	claims, err := s.verifyActive(ctx, token)
+	if err != nil {
+		if errors.Is(err, ErrToken) {
+			return &Introspection{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	return &Introspection{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientId:  claims.ClientId,
+		TokenType: tokenTypeBearer,
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		NotBefore: claims.NotBefore,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		Id:        claims.Id,
+	}, nil
+}
+
+// Revoke records the jti of the token until it expires. A client may revoke its own tokens, an admin client
+// any. Tokens that are invalid already need nothing, RFC 7009 answers 200 for them too.
+func (s *Service) Revoke(ctx context.Context, client *Client, token string) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	claims, err := s.VerifyToken(token)
+	if err != nil {
+		return nil
+	}
+	if claims.ClientId != client.Id && !slices.Contains(client.Scopes, ScopeAdmin) {
+		return newError(ErrUnauthorizedClient.status, ErrUnauthorizedClient.Code, "the token was issued to another client")
+	}
+	// revoked_by is the user the client acts as
+	ctx = session.ContextWithUserID(ctx, client.UserId)
+
+	err = s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		return repo.RevokeToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "token revoked", "jti", claims.Id, "client_id", claims.ClientId, "by", client.Id)
+	return nil
+}
+
+// PruneRevocations forgets the revocations of tokens that expired anyway
+func (s *Service) PruneRevocations(ctx context.Context) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	var pruned int64
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		// the leeway of VerifyToken still accepts them that long
+		pruned, err = repo.PruneRevokedTokens(ctx, time.Now().Add(-leeway))
+		return err
+	})
+
+	return pruned, err
+}
+
+// verifyActive is VerifyToken plus the revocation list
+func (s *Service) verifyActive(ctx context.Context, token string) (*Claims, error) {
+	claims, err := s.VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.isRevoked(ctx, claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errTokenRevoked
+	}
+	return claims, nil
+}
+
+func (s *Service) findRevoked(ctx context.Context, jti string) (bool, error) {
+	// nobody is authenticated yet, the lookup runs as the system
+	ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+	var revoked bool
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		revoked, err = repo.IsTokenRevoked(ctx, jti)
+		return err
+	})
+
+	return revoked, err
+}
+
+// NewRevocationPruneService removes the expired revocations on a schedule
+func NewRevocationPruneService(service *Service, interval time.Duration) utils.Service {
+	return utils.NewScheduledService("oauth-revocation-prune", interval, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+		pruned, err := service.PruneRevocations(ctx)
+		if err != nil {
+			return err
+		}
+
+		if pruned > 0 {
+			slog.InfoContext(ctx, "token revocations pruned", "removed", pruned)
+		}
+		return nil
+	})
+}
+
+// Introspect is the RFC 7662 endpoint, for any registered client
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, r, invalidRequest("token is missing"))
+		return
+	}
+
+	introspection, err := h.service.Introspect(r.Context(), token)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.DebugContext(r.Context(), "token introspected", "by", client.Id, "active", introspection.Active)
+
+	w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
+	w.Header().Set(headers.CacheControl, "no-store")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(introspection)
+}
+
+// Revoke is the RFC 7009 endpoint, it answers 200 with an empty body
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeError(w, r, invalidRequest("token is missing"))
+		return
+	}
+	// only access tokens exist, a refresh_token hint is just a wrong guess
+	if hint := r.PostForm.Get("token_type_hint"); hint != "" && hint != "access_token" && hint != "refresh_token" {
+		writeError(w, r, newError(http.StatusBadRequest, "unsupported_token_type", ""))
+		return
+	}
+
+	if err := h.service.Revoke(r.Context(), client, token); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set(headers.CacheControl, "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient reads the form and authenticates the client making the request, the way the token
+// endpoint does
+func (h *Handler) authenticateClient(w http.ResponseWriter, r *http.Request) (*Client, bool) {
+	if err := parseForm(w, r); err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+
+	clientId, secret, err := clientCredentials(r)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+
+	client, err := h.service.authenticate(r.Context(), clientId, secret)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+	return client, true
+}
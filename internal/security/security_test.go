@@ -0,0 +1,639 @@
+package security
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils/session"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) *SigningKey {
+	key, err := newSigningKey(AlgEdDSA, ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)), time.Time{})
+	require.NoError(t, err)
+	return key
+}
+
+func keyFor(key *SigningKey) func(string) (*PublicKey, error) {
+	return func(kid string) (*PublicKey, error) {
+		if kid != key.Id {
+			return nil, errors.New("unknown kid")
+		}
+		return key.Public(), nil
+	}
+}
+
+func TestSignParseToken(t *testing.T) {
+	req := require.New(t)
+	key := testKey(t)
+
+	claims := &Claims{Issuer: "iss", Subject: "7", Audience: Audience{"golab"}, ExpiresAt: 2, IssuedAt: 1, Id: "x", ClientId: "c"}
+	token, err := SignToken(claims, key)
+	req.NoError(err)
+
+	parsed, err := ParseToken(token, keyFor(key))
+	req.NoError(err)
+	req.Equal(claims, parsed)
+
+	// the payload is changed
+	parts := strings.Split(token, ".")
+	claims.Subject = "0"
+	forged, err := SignToken(claims, key)
+	req.NoError(err)
+	_, err = ParseToken(parts[0]+"."+strings.Split(forged, ".")[1]+"."+parts[2], keyFor(key))
+	req.ErrorIs(err, ErrToken)
+
+	// alg none
+	none := b64.EncodeToString([]byte(`{"alg":"none"}`))
+	_, err = ParseToken(none+"."+parts[1]+".", keyFor(key))
+	req.ErrorIs(err, ErrToken)
+
+	// another key
+	other, err := GenerateSigningKey(AlgEdDSA, time.Now())
+	req.NoError(err)
+	req.NotEqual(key.Id, other.Id)
+	token, err = SignToken(claims, other)
+	req.NoError(err)
+	_, err = ParseToken(token, keyFor(key))
+	req.ErrorIs(err, ErrToken)
+}
+
+func TestAudience(t *testing.T) {
+	req := require.New(t)
+
+	b, err := json.Marshal(Audience{"a"})
+	req.NoError(err)
+	req.Equal(`"a"`, string(b))
+
+	var a Audience
+	req.NoError(json.Unmarshal([]byte(`["a","b"]`), &a))
+	req.True(a.Contains("b"))
+	req.NoError(json.Unmarshal([]byte(`"c"`), &a))
+	req.Equal(Audience{"c"}, a)
+}
+
+func TestSigningKeys(t *testing.T) {
+	req := require.New(t)
+
+	// the kid is the RFC 7638 thumbprint, RFC 8037 appendix A.3
+	seed, err := b64.DecodeString("nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A")
+	req.NoError(err)
+	key, err := newSigningKey(AlgEdDSA, ed25519.NewKeyFromSeed(seed), time.Time{})
+	req.NoError(err)
+	req.Equal("kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k", key.Id)
+
+	jwk, err := key.Public().JWK()
+	req.NoError(err)
+	req.Equal(&JWK{Kty: "OKP", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo", Kid: key.Id, Alg: AlgEdDSA, Use: "sig"}, jwk)
+
+	// RS256
+	rsaKey, err := GenerateSigningKey(AlgRS256, time.Now())
+	req.NoError(err)
+	jwk, err = rsaKey.Public().JWK()
+	req.NoError(err)
+	req.Equal("RSA", jwk.Kty)
+	req.Equal("AQAB", jwk.E)
+
+	claims := &Claims{Issuer: "iss", Subject: "7", Audience: Audience{"golab"}, ExpiresAt: 2, IssuedAt: 1, Id: "x", ClientId: "c"}
+	token, err := SignToken(claims, rsaKey)
+	req.NoError(err)
+	parsed, err := ParseToken(token, keyFor(rsaKey))
+	req.NoError(err)
+	req.Equal(claims, parsed)
+
+	// the alg of the token has to be the one of the key
+	parts := strings.Split(token, ".")
+	header := b64.EncodeToString([]byte(`{"alg":"EdDSA","kid":"` + rsaKey.Id + `"}`))
+	_, err = ParseToken(header+"."+parts[1]+"."+parts[2], keyFor(rsaKey))
+	req.ErrorIs(err, ErrToken)
+
+	_, err = GenerateSigningKey("HS256", time.Now())
+	req.Error(err)
+}
+
+func TestSealKey(t *testing.T) {
+	req := require.New(t)
+
+	kek, err := encryptionKey(base64.StdEncoding.EncodeToString(make([]byte, 32)), false)
+	req.NoError(err)
+
+	for _, alg := range []string{AlgEdDSA, AlgRS256} {
+		key, err := GenerateSigningKey(alg, time.Now())
+		req.NoError(err)
+
+		sealed, err := sealKey(kek, key.Private)
+		req.NoError(err)
+		opened, err := openKey(kek, sealed)
+		req.NoError(err)
+		req.True(key.Private.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(opened.Public()))
+
+		other := make([]byte, 32)
+		other[0] = 1
+		_, err = openKey(other, sealed)
+		req.Error(err)
+	}
+
+	// only dev may go without one
+	_, err = encryptionKey("", false)
+	req.Error(err)
+	kek, err = encryptionKey("", true)
+	req.NoError(err)
+	req.Len(kek, 32)
+	_, err = encryptionKey("c2hvcnQ=", true)
+	req.Error(err)
+}
+
+func TestKeyManager(t *testing.T) {
+	req := require.New(t)
+
+	now := time.Now()
+	key := func(activatesAt time.Time) *SigningKey {
+		k, err := GenerateSigningKey(AlgEdDSA, activatesAt)
+		req.NoError(err)
+		return k
+	}
+	expired := key(now.Add(-72 * time.Hour))
+	retired := key(now.Add(-48 * time.Hour))
+	active := key(now.Add(-30 * time.Minute))
+	pending := key(now.Add(20 * time.Minute))
+
+	m := &KeyManager{
+		cfg:      config.KeyConfig{Alg: AlgEdDSA},
+		tokenTTL: time.Hour,
+		keys:     []*SigningKey{expired, retired, active, pending},
+	}
+
+	// the pending key is published but not used yet
+	current, err := m.Current()
+	req.NoError(err)
+	req.Equal(active.Id, current.Id)
+
+	// retired signed tokens until half an hour ago, they live for an hour
+	set, err := m.JWKS()
+	req.NoError(err)
+	var kids []string
+	for _, jwk := range set.Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	req.Equal([]string{retired.Id, active.Id, pending.Id}, kids)
+
+	// the kid picks the key
+	_, err = m.Lookup(retired.Id)
+	req.NoError(err)
+	_, err = m.Lookup("nope")
+	req.Error(err)
+
+	req.Equal([]string{AlgEdDSA}, m.Algs())
+
+	m.keys = []*SigningKey{pending}
+	_, err = m.Current()
+	req.ErrorIs(err, errNoSigningKey)
+}
+
+func TestScopes(t *testing.T) {
+	req := require.New(t)
+
+	var s Scopes
+	req.NoError(s.Scan([]byte("api:read  api:write")))
+	req.Equal(Scopes{"api:read", "api:write"}, s)
+	req.True(s.Covers(ParseScopes("api:write")))
+	req.True(s.Covers(nil))
+	req.False(s.Covers(ParseScopes("api:read admin")))
+
+	v, err := s.Value()
+	req.NoError(err)
+	req.Equal("api:read api:write", v)
+}
+
+func TestClientSecret(t *testing.T) {
+	req := require.New(t)
+
+	hash, err := HashSecret("s3cret")
+	req.NoError(err)
+	req.True(checkSecret(hash, "s3cret"))
+	req.False(checkSecret(hash, "S3cret"))
+
+	c := &Client{Id: "kiosk", SecretHash: hash}
+	req.NotContains(c.String(), hash)
+}
+
+func testHandler(t *testing.T, env string, fake bool) (*Handler, *SigningKey) {
+	key := testKey(t)
+	auth := config.AuthConfig{
+		ClientID:     "myid",
+		ClientSecret: "my secret",
+		UserID:       42,
+		Scopes:       []string{"api:read", "api:write"},
+		Audience:     "golab",
+		TokenTTL:     time.Hour,
+		FakeToken:    fake,
+	}
+	// the config client never reaches the database
+	keys := &KeyManager{cfg: config.KeyConfig{Alg: AlgEdDSA}, tokenTTL: auth.TokenTTL, keys: []*SigningKey{key}}
+	service := &Service{cfg: auth, issuer: "http://localhost/lab", keys: keys, isRevoked: func(context.Context, string) (bool, error) {
+		return false, nil
+	}}
+	return NewHandler(context.Background(), config.AppConfig{Env: env}, auth, service), key
+}
+
+func tokenRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/security/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set(headers.ContentType, formUrlEncoded)
+	return r
+}
+
+func TestAuth(t *testing.T) {
+	req := require.New(t)
+	h, key := testHandler(t, "prod", false)
+
+	r := tokenRequest(url.Values{"grant_type": {grantClientCredentials}, "scope": {"api:read"}})
+	r.SetBasicAuth("myid", url.QueryEscape("my secret"))
+	w := httptest.NewRecorder()
+	h.Auth(w, r)
+
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal("no-store", w.Header().Get(headers.CacheControl))
+
+	var token Token
+	req.NoError(json.Unmarshal(w.Body.Bytes(), &token))
+	req.Equal("Bearer", token.TokenType)
+	req.Equal(int64(3600), token.ExpiresIn)
+	req.Equal("api:read", token.Scope)
+
+	claims, err := ParseToken(token.AccessToken, keyFor(key))
+	req.NoError(err)
+	req.Equal("42", claims.Subject)
+	req.Equal("myid", claims.ClientId)
+	req.Equal("http://localhost/lab", claims.Issuer)
+	req.Equal(Audience{"golab"}, claims.Audience)
+	req.NotEmpty(claims.Id)
+	req.Equal(claims.IssuedAt+3600, claims.ExpiresAt)
+
+	// credentials in the body, no scope asks for all of them
+	w = httptest.NewRecorder()
+	h.Auth(w, tokenRequest(url.Values{"grant_type": {grantClientCredentials}, "client_id": {"myid"}, "client_secret": {"my secret"}}))
+	req.Equal(http.StatusOK, w.Code)
+	req.NoError(json.Unmarshal(w.Body.Bytes(), &token))
+	req.Equal("api:read api:write", token.Scope)
+}
+
+func TestAuthErrors(t *testing.T) {
+	req := require.New(t)
+	h, _ := testHandler(t, "prod", false)
+
+	tests := []struct {
+		name   string
+		r      *http.Request
+		status int
+		code   string
+	}{
+		{"json body", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+			r.Header.Set(headers.ContentType, "application/json")
+			return r
+		}(), http.StatusBadRequest, "invalid_request"},
+		{"no grant", tokenRequest(url.Values{}), http.StatusBadRequest, "invalid_request"},
+		{"password grant", tokenRequest(url.Values{"grant_type": {"password"}}), http.StatusBadRequest, "unsupported_grant_type"},
+		{"no client", tokenRequest(url.Values{"grant_type": {grantClientCredentials}}), http.StatusUnauthorized, "invalid_client"},
+		{"bad secret", tokenRequest(url.Values{"grant_type": {grantClientCredentials}, "client_id": {"myid"}, "client_secret": {"nope"}}),
+			http.StatusUnauthorized, "invalid_client"},
+		{"bad scope", tokenRequest(url.Values{"grant_type": {grantClientCredentials}, "client_id": {"myid"}, "client_secret": {"my secret"}, "scope": {"admin"}}),
+			http.StatusBadRequest, "invalid_scope"},
+		{"two methods", func() *http.Request {
+			r := tokenRequest(url.Values{"grant_type": {grantClientCredentials}, "client_id": {"myid"}})
+			r.SetBasicAuth("myid", "my+secret")
+			return r
+		}(), http.StatusBadRequest, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.Auth(w, tt.r)
+
+		req.Equal(tt.status, w.Code, tt.name)
+		var body Error
+		req.NoError(json.Unmarshal(w.Body.Bytes(), &body), tt.name)
+		req.Equal(tt.code, body.Code, tt.name)
+		if tt.status == http.StatusUnauthorized {
+			req.Equal(`Basic realm="golab"`, w.Header().Get(headers.WWWAuthenticate), tt.name)
+		}
+	}
+}
+
+func TestAuthFakeToken(t *testing.T) {
+	req := require.New(t)
+
+	// dev alone is not enough
+	h, _ := testHandler(t, "dev", false)
+	w := httptest.NewRecorder()
+	h.Auth(w, tokenRequest(url.Values{"grant_type": {grantClientCredentials}}))
+	req.Equal(http.StatusUnauthorized, w.Code)
+
+	// nor is the flag outside dev
+	h, _ = testHandler(t, "prod", true)
+	w = httptest.NewRecorder()
+	h.Auth(w, tokenRequest(url.Values{"grant_type": {grantClientCredentials}}))
+	req.Equal(http.StatusUnauthorized, w.Code)
+
+	h, _ = testHandler(t, "dev", true)
+	w = httptest.NewRecorder()
+	h.Auth(w, tokenRequest(url.Values{}))
+	req.Equal(http.StatusOK, w.Code)
+	req.Contains(w.Body.String(), "fake-test-token-abc123")
+}
+
+func TestAuthenticate(t *testing.T) {
+	req := require.New(t)
+	h, key := testHandler(t, "prod", false)
+	s := h.service
+
+	var userId int
+	var claims *Claims
+	protected := s.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId, _ = session.UserIDFromContext(r.Context())
+		claims, _ = ClaimsFromContext(r.Context())
+	}))
+	call := func(authorization string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/player", nil)
+		if authorization != "" {
+			r.Header.Set(headers.Authorization, authorization)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w
+	}
+
+	w := call("")
+	req.Equal(http.StatusUnauthorized, w.Code)
+	req.Equal(`Bearer realm="golab"`, w.Header().Get(headers.WWWAuthenticate))
+
+	w = call("Basic bXlpZDpzZWNyZXQ=")
+	req.Equal(http.StatusUnauthorized, w.Code)
+
+	token, err := s.ClientCredentials(context.Background(), "myid", "my secret", "")
+	req.NoError(err)
+	w = call("bearer " + token.AccessToken)
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(42, userId)
+	req.Equal("myid", claims.ClientId)
+
+	sign := func(change func(c *Claims)) string {
+		now := time.Now()
+		c := &Claims{Issuer: s.issuer, Subject: "7", Audience: Audience{"golab"}, ExpiresAt: now.Add(time.Minute).Unix(),
+			NotBefore: now.Unix(), IssuedAt: now.Unix(), Id: "x", ClientId: "c"}
+		change(c)
+		signed, err := SignToken(c, key)
+		req.NoError(err)
+		return "Bearer " + signed
+	}
+
+	w = call(sign(func(c *Claims) { c.ExpiresAt = time.Now().Add(-time.Hour).Unix() }))
+	req.Equal(http.StatusUnauthorized, w.Code)
+	req.Equal(`Bearer realm="golab", error="invalid_token", error_description="the access token expired"`,
+		w.Header().Get(headers.WWWAuthenticate))
+
+	for name, change := range map[string]func(c *Claims){
+		"not yet":  func(c *Claims) { c.NotBefore = time.Now().Add(time.Hour).Unix() },
+		"issuer":   func(c *Claims) { c.Issuer = "http://elsewhere" },
+		"audience": func(c *Claims) { c.Audience = Audience{"other"} },
+		"subject":  func(c *Claims) { c.Subject = "kiosk" },
+	} {
+		w = call(sign(change))
+		req.Equal(http.StatusUnauthorized, w.Code, name)
+		req.Contains(w.Header().Get(headers.WWWAuthenticate), `error="invalid_token"`, name)
+	}
+
+	// the leeway covers a little skew
+	w = call(sign(func(c *Claims) { c.ExpiresAt = time.Now().Add(-10 * time.Second).Unix() }))
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(7, userId)
+}
+
+func TestRequireScope(t *testing.T) {
+	req := require.New(t)
+
+	lookups := 0
+	roles := map[int]Scopes{1: {ScopeRead}, 2: {ScopeRead, ScopeWrite}}
+	a := newAuthorizer(func(_ context.Context, userId int) (Scopes, error) {
+		lookups++
+		return roles[userId], nil
+	}, time.Minute)
+
+	handler := a.RequireScope(ScopeWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(userId int, scope string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/player", nil)
+		ctx := session.ContextWithUserID(r.Context(), userId)
+		ctx = context.WithValue(ctx, claimsKey{}, &Claims{Subject: strconv.Itoa(userId), Scope: scope})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+		return w
+	}
+
+	w := call(2, "api:read api:write")
+	req.Equal(http.StatusOK, w.Code)
+
+	// the token lacks it
+	w = call(2, "api:read")
+	req.Equal(http.StatusForbidden, w.Code)
+	req.Contains(w.Header().Get(headers.WWWAuthenticate), `error="insufficient_scope", scope="api:write"`)
+
+	// the role lacks it
+	w = call(1, "api:read api:write")
+	req.Equal(http.StatusForbidden, w.Code)
+	req.Empty(w.Header().Get(headers.WWWAuthenticate))
+
+	// unknown user
+	w = call(3, "api:write")
+	req.Equal(http.StatusForbidden, w.Code)
+
+	// cached per user
+	w = call(2, "api:write")
+	req.Equal(http.StatusOK, w.Code)
+	req.Equal(3, lookups)
+
+	// not authenticated
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/player", nil))
+	req.Equal(http.StatusUnauthorized, w.Code)
+}
+
+func TestHas(t *testing.T) {
+	req := require.New(t)
+
+	roles := map[int]Scopes{0: {ScopeRead}, 5: {ScopeRead, ScopeAdmin}}
+	a := newAuthorizer(func(_ context.Context, userId int) (Scopes, error) {
+		return roles[userId], nil
+	}, time.Minute)
+
+	has := func(userId int, scope string) bool {
+		ctx := session.ContextWithUserID(context.Background(), userId)
+		ctx = context.WithValue(ctx, claimsKey{}, &Claims{Subject: strconv.Itoa(userId), Scope: scope})
+		ok, err := a.Has(ctx, ScopeAdmin)
+		req.NoError(err)
+		return ok
+	}
+
+	req.True(has(5, "api:read admin"))
+	// the token lacks it
+	req.False(has(5, "api:read"))
+	// the role lacks it, user 0 is no admin by its id
+	req.False(has(0, "api:read admin"))
+
+	// not authenticated
+	ok, err := a.Has(context.Background(), ScopeAdmin)
+	req.NoError(err)
+	req.False(ok)
+}
+
+func TestAPIKey(t *testing.T) {
+	req := require.New(t)
+
+	k := &APIKey{Name: "kiosk", OwnerId: 1001, Scopes: Scopes{ScopeRead}}
+	plain, err := newAPIKey(k)
+	req.NoError(err)
+	req.True(strings.HasPrefix(plain, "glk_"+k.Prefix+"_"))
+	req.Len(k.KeyHash, 64)
+	req.NotContains(k.String(), k.KeyHash)
+	req.NoError(k.Validate())
+
+	prefix, ok := apiKeyLookup(plain)
+	req.True(ok)
+	req.Equal(k.Prefix, prefix)
+	req.True(k.matches(plain))
+	req.False(k.matches(plain + "x"))
+
+	for _, bad := range []string{"", "glk_", "glk_abc_x", "xyz_12345678_secret", "glk_12345678_"} {
+		_, ok = apiKeyLookup(bad)
+		req.False(ok, bad)
+	}
+
+	other := &APIKey{}
+	_, err = newAPIKey(other)
+	req.NoError(err)
+	req.NotEqual(k.Prefix, other.Prefix)
+
+	now := time.Now()
+	req.True(k.Usable(now))
+	past := now.Add(-time.Second)
+	k.ExpiresAt = &past
+	req.False(k.Usable(now))
+	k.ExpiresAt = nil
+	k.RevokedAt = &past
+	req.False(k.Usable(now))
+
+	k.Scopes = Scopes{"root"}
+	req.Error(k.Validate())
+	k.Scopes = nil
+	req.Error(k.Validate())
+}
+
+func TestIntrospectRevoke(t *testing.T) {
+	req := require.New(t)
+	h, key := testHandler(t, "prod", false)
+
+	token, err := h.service.ClientCredentials(context.Background(), "myid", "my secret", "api:read")
+	req.NoError(err)
+
+	introspect := func(token string) Introspection {
+		r := tokenRequest(url.Values{"token": {token}})
+		r.SetBasicAuth("myid", url.QueryEscape("my secret"))
+		w := httptest.NewRecorder()
+		h.Introspect(w, r)
+		req.Equal(http.StatusOK, w.Code)
+
+		var i Introspection
+		req.NoError(json.Unmarshal(w.Body.Bytes(), &i))
+		return i
+	}
+
+	i := introspect(token.AccessToken)
+	req.True(i.Active)
+	req.Equal("api:read", i.Scope)
+	req.Equal("myid", i.ClientId)
+	req.Equal("42", i.Subject)
+
+	req.Equal(Introspection{Active: false}, introspect("not.a.token"))
+
+	// revoked
+	h.service.isRevoked = func(_ context.Context, jti string) (bool, error) {
+		return jti == i.Id, nil
+	}
+	req.False(introspect(token.AccessToken).Active)
+
+	r := httptest.NewRequest(http.MethodGet, "/player", nil)
+	r.Header.Set(headers.Authorization, "Bearer "+token.AccessToken)
+	w := httptest.NewRecorder()
+	h.service.Authenticate(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(w, r)
+	req.Equal(http.StatusUnauthorized, w.Code)
+	req.Contains(w.Header().Get(headers.WWWAuthenticate), "revoked")
+
+	// the client has to authenticate
+	w = httptest.NewRecorder()
+	h.Introspect(w, tokenRequest(url.Values{"token": {token.AccessToken}}))
+	req.Equal(http.StatusUnauthorized, w.Code)
+
+	// garbage is revoked already
+	r = tokenRequest(url.Values{"token": {"garbage"}})
+	r.SetBasicAuth("myid", url.QueryEscape("my secret"))
+	w = httptest.NewRecorder()
+	h.Revoke(w, r)
+	req.Equal(http.StatusOK, w.Code)
+
+	// only the owning client or an admin one
+	claims := &Claims{Issuer: h.service.issuer, Subject: "7", Audience: Audience{"golab"}, ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		Id: "y", ClientId: "kiosk"}
+	foreign, err := SignToken(claims, key)
+	req.NoError(err)
+	err = h.service.Revoke(context.Background(), &Client{Id: "other", Scopes: Scopes{ScopeRead}}, foreign)
+	req.ErrorIs(err, ErrUnauthorizedClient)
+}
+
+func TestWellKnown(t *testing.T) {
+	req := require.New(t)
+	h, key := testHandler(t, "prod", false)
+
+	w := httptest.NewRecorder()
+	h.JWKS(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	req.Equal(http.StatusOK, w.Code)
+
+	var set JWKSet
+	req.NoError(json.Unmarshal(w.Body.Bytes(), &set))
+	req.Len(set.Keys, 1)
+	req.Equal(key.Id, set.Keys[0].Kid)
+	req.NotContains(w.Body.String(), `"d"`)
+
+	w = httptest.NewRecorder()
+	h.Discovery(w, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	req.Equal(http.StatusOK, w.Code)
+
+	var discovery Discovery
+	req.NoError(json.Unmarshal(w.Body.Bytes(), &discovery))
+	req.Equal("http://localhost/lab", discovery.Issuer)
+	req.Equal("http://localhost/lab/.well-known/jwks.json", discovery.JwksUri)
+	req.Equal("http://localhost/lab/security/oauth/token", discovery.TokenEndpoint)
+	req.Equal([]string{AlgEdDSA}, discovery.AccessTokenSigningAlgs)
+}
+
+func TestRateLimitKey(t *testing.T) {
+	req := require.New(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/player", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	req.Equal("ip:10.0.0.1", RateLimitKey(r))
+
+	r = r.WithContext(context.WithValue(r.Context(), claimsKey{}, &Claims{ClientId: "apikey:0a1b2c3d"}))
+	req.Equal("client:apikey:0a1b2c3d", RateLimitKey(r))
+}
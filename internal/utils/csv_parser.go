@@ -9,6 +9,7 @@ import (
 )
 
 type CsvParser struct {
+	err error
 }
 
 func NewCsvParser() *CsvParser {
@@ -42,9 +43,11 @@ func (p *CsvParser) Parse(file *os.File, onParsed func(row []string)) error {
 	return nil
 }
 
-// Rows uses an iterator (better imo)
+// Rows uses an iterator (better imo). The iteration stops on the first malformed row, check Err afterwards.
 func (p *CsvParser) Rows(r io.Reader) Iter[[]string] {
 	return func(yield func([]string) bool) {
+		p.err = nil
+
 		cr := csv.NewReader(r)
 		cr.ReuseRecord = true // be kind to the garbage collector
 
@@ -54,8 +57,8 @@ func (p *CsvParser) Rows(r io.Reader) Iter[[]string] {
 				return
 			}
 			if err != nil {
-				// For now, panic. Could also store error externally
-				panic(err)
+				p.err = err
+				return
 			}
 
 			if !yield(row) {
@@ -65,3 +68,8 @@ func (p *CsvParser) Rows(r io.Reader) Iter[[]string] {
 		}
 	}
 }
+
+// Err returns the error that ended the last Rows iteration, if any
+func (p *CsvParser) Err() error {
+	return p.err
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -102,3 +103,17 @@ func afterEach() {
 		fmt.Printf("error removing file: %s\n", err)
 	}
 }
+
+func TestCsvParser_RowsErr(t *testing.T) {
+	req := require.New(t)
+
+	csvParser := NewCsvParser()
+
+	numLines := 0
+	for range csvParser.Rows(strings.NewReader("a,b\nc,d\n\"e,f\n")) {
+		numLines++
+	}
+
+	req.Equal(2, numLines)
+	req.Error(csvParser.Err())
+}
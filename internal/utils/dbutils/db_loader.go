@@ -41,10 +41,10 @@ func (db *DbLoader) Load(ctx context.Context, scriptFilename string) error {
 		return err
 	}
 
-	slog.Info("running scripts...")
+	slog.InfoContext(ctx, "running scripts...")
 
 	// admin user
-	ctx = session.ContextWithUserID(ctx, 0)
+	ctx = session.ContextWithUserID(ctx, session.AdminUserID)
 
 	err = db.utils.WithTransaction(ctx, func(tx *sqlx.Tx) error {
 		userID, found := session.UserIDFromContext(ctx)
@@ -57,7 +57,7 @@ func (db *DbLoader) Load(ctx context.Context, scriptFilename string) error {
 		return err
 	})
 
-	slog.Info("ran scripts.")
+	slog.InfoContext(ctx, "ran scripts.")
 
 	return err
 }
@@ -33,6 +33,54 @@ func NewDbUtils(config *config.DBConfig) *DbUtils {
 	}
 }
 
+type txKey struct{}
+
+// joinedTx is a transaction owned by the caller of ContextWithTx, with the work that waits for its commit
+type joinedTx struct {
+	tx          *sqlx.Tx
+	mu          sync.Mutex
+	afterCommit []func()
+}
+
+// ContextWithTx makes WithTransaction run in tx instead of a transaction of its own, for a caller that has to
+// commit its own writes together with the ones of a service. tx must not be shared between goroutines.
+// The caller runs Committed with the returned context once tx has committed.
+func ContextWithTx(ctx context.Context, tx *sqlx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, &joinedTx{tx: tx})
+}
+
+// AfterCommit runs fn once the changes made with ctx are committed. Call it after WithTransaction returned nil:
+// in a transaction of its own fn runs right away, in a joined one it waits for Committed of the owner and is
+// dropped when the owner rolls back.
+func AfterCommit(ctx context.Context, fn func()) {
+	j, ok := ctx.Value(txKey{}).(*joinedTx)
+	if !ok {
+		fn()
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.afterCommit = append(j.afterCommit, fn)
+}
+
+// Committed runs what AfterCommit queued on the transaction of ctx, the owner calls it after the commit
+func Committed(ctx context.Context) {
+	j, ok := ctx.Value(txKey{}).(*joinedTx)
+	if !ok {
+		return
+	}
+
+	j.mu.Lock()
+	fns := j.afterCommit
+	j.afterCommit = nil
+	j.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
 func (dbUtils *DbUtils) WithTransaction(ctx context.Context, txFunc func(*sqlx.Tx) error) error {
 	if err := validate.Get().Var(ctx, "required"); err != nil {
 		return err
@@ -42,6 +90,11 @@ func (dbUtils *DbUtils) WithTransaction(ctx context.Context, txFunc func(*sqlx.T
 		return err
 	}
 
+	// joined, the owner of the transaction commits or rolls back
+	if j, ok := ctx.Value(txKey{}).(*joinedTx); ok {
+		return txFunc(j.tx)
+	}
+
 	con, err := dbUtils.DB.Connx(ctx)
 	if err != nil {
 		return err
@@ -49,10 +102,10 @@ func (dbUtils *DbUtils) WithTransaction(ctx context.Context, txFunc func(*sqlx.T
 
 	defer func() {
 		if err := resetSession(ctx, con); err != nil {
-			slog.Error("failed to reset session", "error", err)
+			slog.ErrorContext(ctx, "failed to reset session", "error", err)
 		}
 		if err := con.Close(); err != nil {
-			slog.Error("failed to close connection", "error", err)
+			slog.ErrorContext(ctx, "failed to close connection", "error", err)
 		}
 	}()
 
@@ -119,21 +172,30 @@ func (dbUtils *DbUtils) Close() {
 	log.Println("Closed the database.")
 }
 
+// resetSession clears the variables a pooled connection must not carry over, @allow_player_purge disables the
+// delete trigger of player_entity
 func resetSession(ctx context.Context, con *sqlx.Conn) error {
-	_, err := con.ExecContext(ctx, "SET @session_user_id = NULL")
+	_, err := con.ExecContext(context.WithoutCancel(ctx),
+		"SET @session_user_id = NULL, @session_trace_id = NULL, @allow_player_purge = NULL")
 	return err
 }
 
 func initSessionVars(ctx context.Context, conn *sqlx.Conn) error {
 	userId, found := session.UserIDFromContext(ctx)
+	// the audit rows carry the trace of the request that wrote them
+	traceId, traced := session.TraceIDFromContext(ctx)
 
-	var err error
+	var user, trace any
 	if !found {
-		slog.Warn("No user ID found in context!")
-		_, err = conn.ExecContext(ctx, "SET @session_user_id = NULL")
+		slog.WarnContext(ctx, "No user ID found in context!")
 	} else {
-		_, err = conn.ExecContext(ctx, "SET @session_user_id = ?", userId)
+		user = userId
+	}
+	if traced {
+		trace = traceId
 	}
 
+	// a failed reset of the previous checkout leaves nothing behind either
+	_, err := conn.ExecContext(ctx, "SET @session_user_id = ?, @session_trace_id = ?, @allow_player_purge = NULL", user, trace)
 	return err
 }
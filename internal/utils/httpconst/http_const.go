@@ -1,5 +1,10 @@
 package httpconst
 
 const (
-	ApplicationJSON = "application/json"
+	ApplicationJSON           = "application/json"
+	ApplicationMergePatchJSON = "application/merge-patch+json"
+	ApplicationJSONPatchJSON  = "application/json-patch+json"
+	ApplicationNDJSON         = "application/x-ndjson"
+	ApplicationProblemJSON    = "application/problem+json"
+	TextCSV                   = "text/csv"
 )
@@ -1,15 +1,51 @@
 package paging
 
 import (
+	"Go-lab/internal/utils/problem"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
+	"strings"
 )
 
-const DefaultLimit uint = 20
+const (
+	DefaultLimit uint = 20
+	MaxLimit     uint = 100 // more at once is what the export is for
+)
+
+var ErrInvalid = problem.NewError(http.StatusBadRequest, "invalid paging")
+
+const (
+	ParamPage   = "page"
+	ParamLimit  = "limit"
+	ParamCursor = "cursor"
+	ParamTotal  = "total"
+)
+
+type Mode int
+
+const (
+	Offset Mode = iota // classic page/limit, kept for existing clients
+	Keyset             // opaque cursor tokens, see Cursor
+)
+
+type Direction string
+
+const (
+	Next Direction = "next"
+	Prev Direction = "prev"
+)
 
 type Paging struct {
-	Page  uint `json:"page"` // 0-based
-	Limit uint `json:"limit"`
+	Mode   Mode    `json:"-"`
+	Page   uint    `json:"page"` // 0-based, Offset mode only
+	Limit  uint    `json:"limit"`
+	Cursor *Cursor `json:"-"` // Keyset mode only, nil means the first page
+	Total  bool    `json:"-"` // whether the caller wants the total row count
 }
 
 func NewPaging(page uint, limit uint) Paging {
@@ -19,19 +55,212 @@ func NewPaging(page uint, limit uint) Paging {
 	return Paging{Page: page, Limit: limit}
 }
 
+func NewKeysetPaging(cursor *Cursor, limit uint) Paging {
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	return Paging{Mode: Keyset, Cursor: cursor, Limit: limit}
+}
+
 // Offset returns the starting index for the current page.
 func (p Paging) Offset() uint {
 	return p.Page * p.Limit
 }
 
+// Fetch returns the number of rows a repo should select: one more than the limit, so we know if there is more.
+func (p Paging) Fetch() uint {
+	return p.Limit + 1
+}
+
+func (p Paging) IsKeyset() bool {
+	return p.Mode == Keyset
+}
+
+// Backward is true when walking a keyset towards the start of the collection.
+func (p Paging) Backward() bool {
+	return p.Cursor != nil && p.Cursor.Dir == Prev
+}
+
+// Cursor marks the boundary row of a keyset page. Keys holds the sort values of that row, Id breaks ties.
+type Cursor struct {
+	Keys []string  `json:"k"`
+	Id   uint      `json:"i"`
+	Dir  Direction `json:"d"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c) // cannot fail, plain strings and numbers only
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalid)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalid)
+	}
+	if c.Dir != Next && c.Dir != Prev {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalid)
+	}
+
+	return &c, nil
+}
+
+// Page is the envelope returned to keyset clients.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	Total      *uint   `json:"total,omitempty"`
+
+	HasNext bool `json:"-"`
+	HasPrev bool `json:"-"`
+}
+
+// NewPage trims rows fetched with Paging.Fetch down to the limit and works out the neighbouring cursors.
+// Rows fetched backwards must arrive in reverse sort order; they are flipped here.
+func NewPage[T any](rows []T, p Paging, key func(T) Cursor) Page[T] {
+	more := uint(len(rows)) > p.Limit
+	if more {
+		rows = rows[:p.Limit]
+	}
+	if p.Backward() {
+		slices.Reverse(rows)
+	}
+	if rows == nil {
+		rows = []T{}
+	}
+
+	page := Page[T]{Items: rows}
+
+	if p.IsKeyset() {
+		if p.Backward() {
+			page.HasPrev = more
+			page.HasNext = true
+		} else {
+			page.HasNext = more
+			page.HasPrev = p.Cursor != nil
+		}
+	} else {
+		page.HasNext = more
+		page.HasPrev = p.Page > 0
+	}
+
+	if len(rows) > 0 {
+		if page.HasNext {
+			c := key(rows[len(rows)-1])
+			c.Dir = Next
+			s := c.Encode()
+			page.NextCursor = &s
+		}
+		if page.HasPrev {
+			c := key(rows[0])
+			c.Dir = Prev
+			s := c.Encode()
+			page.PrevCursor = &s
+		}
+	}
+
+	return page
+}
+
+// Map converts the items of a page, keeping the cursors.
+func Map[T, R any](p Page[T], f func([]T) ([]R, error)) (Page[R], error) {
+	items, err := f(p.Items)
+	if err != nil {
+		return Page[R]{}, err
+	}
+	return Page[R]{
+		Items:      items,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+		Total:      p.Total,
+		HasNext:    p.HasNext,
+		HasPrev:    p.HasPrev,
+	}, nil
+}
+
+// Parse reads the paging query parameters. The presence of the cursor parameter (even empty) selects Keyset mode.
+func Parse(q url.Values) (Paging, error) {
+	limit, err := ParseLimit(q.Get(ParamLimit))
+	if err != nil {
+		return Paging{}, err
+	}
+
+	var p Paging
+	if q.Has(ParamCursor) {
+		var cursor *Cursor
+		if s := q.Get(ParamCursor); s != "" {
+			if cursor, err = DecodeCursor(s); err != nil {
+				return Paging{}, err
+			}
+		}
+		p = NewKeysetPaging(cursor, limit)
+	} else {
+		page, err := ParsePage(q.Get(ParamPage))
+		if err != nil {
+			return Paging{}, err
+		}
+		p = NewPaging(page, limit)
+	}
+
+	if s := q.Get(ParamTotal); s != "" {
+		if p.Total, err = strconv.ParseBool(s); err != nil {
+			return Paging{}, fmt.Errorf("%w: bad total", ErrInvalid)
+		}
+	}
+
+	return p, nil
+}
+
+// Links builds an RFC 8288 Link header value for the page, based on the request URL.
+func Links[T any](u url.URL, p Paging, page Page[T]) string {
+	link := func(rel string, set func(q url.Values)) string {
+		q := u.Query()
+		q.Set(ParamLimit, strconv.FormatUint(uint64(p.Limit), 10))
+		set(q)
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf(`<%s>; rel="%s"`, u.String(), rel)
+	}
+
+	var links []string
+
+	if p.IsKeyset() {
+		links = append(links, link("first", func(q url.Values) { q.Set(ParamCursor, "") }))
+		if page.NextCursor != nil {
+			links = append(links, link("next", func(q url.Values) { q.Set(ParamCursor, *page.NextCursor) }))
+		}
+		if page.PrevCursor != nil {
+			links = append(links, link("prev", func(q url.Values) { q.Set(ParamCursor, *page.PrevCursor) }))
+		}
+	} else {
+		setPage := func(n uint) func(q url.Values) {
+			return func(q url.Values) { q.Set(ParamPage, strconv.FormatUint(uint64(n), 10)) }
+		}
+		links = append(links, link("first", setPage(0)))
+		if page.HasNext {
+			links = append(links, link("next", setPage(p.Page+1)))
+		}
+		if page.HasPrev {
+			links = append(links, link("prev", setPage(p.Page-1)))
+		}
+	}
+
+	return strings.Join(links, ", ")
+}
+
 func ParsePage(pageStr string) (uint, error) {
 	if pageStr == "" {
 		return 0, nil
 	}
 
 	p, err := strconv.Atoi(pageStr)
-	if err != nil {
-		return 0, fmt.Errorf("invalid page number")
+	if err != nil || p < 0 {
+		return 0, fmt.Errorf("%w: bad page number", ErrInvalid)
 	}
 
 	return uint(p), nil
@@ -43,8 +272,11 @@ func ParseLimit(limitStr string) (uint, error) {
 	}
 
 	p, err := strconv.Atoi(limitStr)
-	if err != nil {
-		return 0, fmt.Errorf("invalid limit")
+	if err != nil || p < 0 {
+		return 0, fmt.Errorf("%w: bad limit", ErrInvalid)
+	}
+	if uint(p) > MaxLimit {
+		return 0, fmt.Errorf("%w: limit must not exceed %d", ErrInvalid, MaxLimit)
 	}
 
 	return uint(p), nil
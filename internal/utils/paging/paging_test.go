@@ -1,6 +1,7 @@
 package paging
 
 import (
+	"net/url"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -36,3 +37,101 @@ func TestNewPaging(t *testing.T) {
 	req.Equal(uint(11), paging.Limit)
 	req.Equal(uint(22), paging.Offset())
 }
+
+func TestCursor(t *testing.T) {
+	req := require.New(t)
+
+	c := Cursor{Keys: []string{"Player One"}, Id: 7, Dir: Next}
+	decoded, err := DecodeCursor(c.Encode())
+	req.NoError(err)
+	req.Equal(c, *decoded)
+
+	_, err = DecodeCursor("not a cursor")
+	req.Error(err)
+	_, err = DecodeCursor(Cursor{Keys: []string{"x"}, Id: 1, Dir: "sideways"}.Encode())
+	req.Error(err)
+}
+
+func TestParse(t *testing.T) {
+	req := require.New(t)
+
+	p, err := Parse(url.Values{"page": {"2"}, "limit": {"5"}})
+	req.NoError(err)
+	req.False(p.IsKeyset())
+	req.Equal(uint(10), p.Offset())
+
+	p, err = Parse(url.Values{"cursor": {""}, "total": {"true"}})
+	req.NoError(err)
+	req.True(p.IsKeyset())
+	req.Nil(p.Cursor)
+	req.True(p.Total)
+	req.Equal(DefaultLimit, p.Limit)
+
+	_, err = Parse(url.Values{"cursor": {"???"}})
+	req.Error(err)
+	_, err = Parse(url.Values{"limit": {"-1"}})
+	req.Error(err)
+
+	p, err = Parse(url.Values{"limit": {"100"}})
+	req.NoError(err)
+	req.Equal(MaxLimit, p.Limit)
+	_, err = Parse(url.Values{"limit": {"100000000"}})
+	req.ErrorIs(err, ErrInvalid)
+}
+
+type row struct {
+	name string
+	id   uint
+}
+
+func key(r row) Cursor {
+	return Cursor{Keys: []string{r.name}, Id: r.id}
+}
+
+func TestNewPage(t *testing.T) {
+	req := require.New(t)
+
+	rows := []row{{"a", 1}, {"b", 2}, {"c", 3}}
+
+	// first keyset page, one row more than the limit
+	page := NewPage(rows, NewKeysetPaging(nil, 2), key)
+	req.Len(page.Items, 2)
+	req.NotNil(page.NextCursor)
+	req.Nil(page.PrevCursor)
+	next, err := DecodeCursor(*page.NextCursor)
+	req.NoError(err)
+	req.Equal(Cursor{Keys: []string{"b"}, Id: 2, Dir: Next}, *next)
+
+	// walking backwards, rows arrive in reverse order
+	page = NewPage([]row{{"c", 3}, {"b", 2}}, NewKeysetPaging(&Cursor{Keys: []string{"d"}, Id: 4, Dir: Prev}, 2), key)
+	req.Equal([]row{{"b", 2}, {"c", 3}}, page.Items)
+	req.NotNil(page.NextCursor)
+	req.Nil(page.PrevCursor)
+
+	// last offset page
+	page = NewPage(rows[:1], NewPaging(1, 2), key)
+	req.False(page.HasNext)
+	req.True(page.HasPrev)
+
+	// empty
+	page = NewPage[row](nil, NewKeysetPaging(nil, 2), key)
+	req.NotNil(page.Items)
+	req.Nil(page.NextCursor)
+}
+
+func TestLinks(t *testing.T) {
+	req := require.New(t)
+
+	u, _ := url.Parse("http://localhost/lab/player?page=1&limit=2")
+	p := NewPaging(1, 2)
+	links := Links(*u, p, Page[row]{HasNext: true, HasPrev: true})
+	req.Contains(links, `<http://localhost/lab/player?limit=2&page=0>; rel="first"`)
+	req.Contains(links, `<http://localhost/lab/player?limit=2&page=2>; rel="next"`)
+	req.Contains(links, `<http://localhost/lab/player?limit=2&page=0>; rel="prev"`)
+
+	next := "abc"
+	links = Links(*u, NewKeysetPaging(nil, 2), Page[row]{NextCursor: &next})
+	req.Contains(links, `cursor=abc`)
+	req.Contains(links, `rel="next"`)
+	req.NotContains(links, `rel="prev"`)
+}
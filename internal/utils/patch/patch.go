@@ -0,0 +1,325 @@
+package patch
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"Go-lab/internal/utils/problem"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupported = problem.NewError(http.StatusUnsupportedMediaType, "unsupported patch media type")
+	ErrInvalid     = problem.NewError(http.StatusBadRequest, "invalid patch")
+	ErrTestFailed  = problem.NewError(http.StatusConflict, "patch test failed")
+)
+
+// Accept lists the media types Apply understands, for the Accept-Patch header.
+var Accept = strings.Join([]string{httpconst.ApplicationMergePatchJSON, httpconst.ApplicationJSONPatchJSON}, ", ")
+
+// Apply patches a JSON document with either a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902).
+func Apply(mediaType string, doc, patch []byte) ([]byte, error) {
+	switch mediaType {
+	case httpconst.ApplicationMergePatchJSON:
+		return MergePatch(doc, patch)
+	case httpconst.ApplicationJSONPatchJSON:
+		return JSONPatch(doc, patch)
+	default:
+		return nil, fmt.Errorf("%w: '%s'", ErrUnsupported, mediaType)
+	}
+}
+
+////////// RFC 7396 //////////
+
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	return json.Marshal(merge(target, p))
+}
+
+func merge(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch // non-objects replace the target wholesale
+	}
+
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = merge(t[k], v)
+	}
+
+	return t
+}
+
+////////// RFC 6902 //////////
+
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	for i, op := range ops {
+		var err error
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func (op operation) apply(doc any) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", ErrInvalid)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalid)
+		}
+		var v any
+		if err := json.Unmarshal(*op.Value, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, v)
+		case "replace":
+			if doc, err = remove(doc, path); err != nil {
+				return nil, err
+			}
+			return add(doc, path, v)
+		default:
+			actual, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(actual, v) {
+				return nil, fmt.Errorf("%w: '%s'", ErrTestFailed, *op.Path)
+			}
+			return doc, nil
+		}
+
+	case "remove":
+		return remove(doc, path)
+
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", ErrInvalid)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		v, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			if strings.HasPrefix(*op.Path+"/", *op.From+"/") && *op.Path != *op.From {
+				return nil, fmt.Errorf("%w: cannot move a value into itself", ErrInvalid)
+			}
+			if doc, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			v = deepCopy(v)
+		}
+		return add(doc, path, v)
+
+	default:
+		return nil, fmt.Errorf("%w: unknown op '%s'", ErrInvalid, op.Op)
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("%w: bad pointer '%s'", ErrInvalid, p)
+	}
+
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+func get(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch d := doc.(type) {
+		case map[string]any:
+			v, ok := d[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: no such member '%s'", ErrInvalid, token)
+			}
+			doc = v
+		case []any:
+			i, err := index(token, len(d)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = d[i]
+		default:
+			return nil, fmt.Errorf("%w: cannot descend into '%s'", ErrInvalid, token)
+		}
+	}
+	return doc, nil
+}
+
+// update replaces the container at path with whatever f makes of it. Slices can grow, hence the rebuild.
+func update(doc any, path []string, f func(any) (any, error)) (any, error) {
+	if len(path) == 0 {
+		return f(doc)
+	}
+
+	switch d := doc.(type) {
+	case map[string]any:
+		child, ok := d[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: no such member '%s'", ErrInvalid, path[0])
+		}
+		v, err := update(child, path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		d[path[0]] = v
+		return d, nil
+	case []any:
+		i, err := index(path[0], len(d)-1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := update(d[i], path[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		d[i] = v
+		return d, nil
+	default:
+		return nil, fmt.Errorf("%w: cannot descend into '%s'", ErrInvalid, path[0])
+	}
+}
+
+func add(doc any, path []string, v any) (any, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	last := path[len(path)-1]
+
+	return update(doc, path[:len(path)-1], func(parent any) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[last] = v
+			return p, nil
+		case []any:
+			if last == "-" {
+				return append(p, v), nil
+			}
+			i, err := index(last, len(p))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = v
+			return p, nil
+		default:
+			return nil, fmt.Errorf("%w: cannot add to '%s'", ErrInvalid, last)
+		}
+	})
+}
+
+func remove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	last := path[len(path)-1]
+
+	return update(doc, path[:len(path)-1], func(parent any) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			if _, ok := p[last]; !ok {
+				return nil, fmt.Errorf("%w: no such member '%s'", ErrInvalid, last)
+			}
+			delete(p, last)
+			return p, nil
+		case []any:
+			i, err := index(last, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(p[:i], p[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: cannot remove from '%s'", ErrInvalid, last)
+		}
+	})
+}
+
+func index(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: bad array index '%s'", ErrInvalid, token)
+	}
+	return i, nil
+}
+
+func deepCopy(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			m[k] = deepCopy(e)
+		}
+		return m
+	case []any:
+		s := make([]any, len(t))
+		for i, e := range t {
+			s[i] = deepCopy(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
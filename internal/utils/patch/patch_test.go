@@ -0,0 +1,74 @@
+package patch
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePatch(t *testing.T) {
+	req := require.New(t)
+
+	res, err := MergePatch(
+		[]byte(`{"name":"Jono","description":"desc","tags":{"a":1,"b":2}}`),
+		[]byte(`{"description":null,"tags":{"b":null,"c":3}}`),
+	)
+	req.NoError(err)
+	req.JSONEq(`{"name":"Jono","tags":{"a":1,"c":3}}`, string(res))
+
+	_, err = MergePatch([]byte(`{}`), []byte(`{`))
+	req.True(errors.Is(err, ErrInvalid))
+}
+
+func TestJSONPatch(t *testing.T) {
+	req := require.New(t)
+
+	res, err := JSONPatch(
+		[]byte(`{"name":"Jono","list":[1,2,3],"a/b":{"c":1}}`),
+		[]byte(`[
+			{"op":"test","path":"/name","value":"Jono"},
+			{"op":"replace","path":"/name","value":"John"},
+			{"op":"add","path":"/list/1","value":9},
+			{"op":"add","path":"/list/-","value":4},
+			{"op":"remove","path":"/list/0"},
+			{"op":"copy","from":"/a~1b","path":"/copied"},
+			{"op":"move","from":"/a~1b/c","path":"/moved"}
+		]`),
+	)
+	req.NoError(err)
+	req.JSONEq(`{"name":"John","list":[9,2,3,4],"a/b":{},"copied":{"c":1},"moved":1}`, string(res))
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	req := require.New(t)
+
+	doc := []byte(`{"name":"Jono","list":[1]}`)
+
+	_, err := JSONPatch(doc, []byte(`[{"op":"test","path":"/name","value":"John"}]`))
+	req.True(errors.Is(err, ErrTestFailed))
+
+	for _, p := range []string{
+		`{}`,
+		`[{"op":"nope","path":"/name"}]`,
+		`[{"op":"remove","path":"/missing"}]`,
+		`[{"op":"replace","path":"/list/5","value":1}]`,
+		`[{"op":"add","path":"name","value":1}]`,
+		`[{"op":"add","path":"/name"}]`,
+		`[{"op":"move","from":"/list","path":"/list/0"}]`,
+	} {
+		_, err := JSONPatch(doc, []byte(p))
+		req.True(errors.Is(err, ErrInvalid), p)
+	}
+}
+
+func TestApply(t *testing.T) {
+	req := require.New(t)
+
+	_, err := Apply("application/json", []byte(`{}`), []byte(`{}`))
+	req.True(errors.Is(err, ErrUnsupported))
+
+	res, err := Apply("application/merge-patch+json", []byte(`{"a":1}`), []byte(`{"b":2}`))
+	req.NoError(err)
+	req.JSONEq(`{"a":1,"b":2}`, string(res))
+}
@@ -0,0 +1,163 @@
+package problem
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-http-utils/headers"
+	"github.com/go-playground/validator/v10"
+)
+
+const typePrefix = "urn:golab:problem:"
+
+// StatusError is a sentinel error that knows which HTTP status it maps to.
+// Domain packages declare theirs with NewError and wrap them as usual, fmt.Errorf("%w: ...", ErrX).
+type StatusError struct {
+	Status  int
+	Message string
+}
+
+func NewError(status int, message string) *StatusError {
+	return &StatusError{Status: status, Message: message}
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrBadRequest           = NewError(http.StatusBadRequest, "bad request")
+	ErrUnauthorized         = NewError(http.StatusUnauthorized, "unauthorized")
+	ErrForbidden            = NewError(http.StatusForbidden, "forbidden")
+	ErrNotFound             = NewError(http.StatusNotFound, "not found")
+	ErrConflict             = NewError(http.StatusConflict, "conflict")
+	ErrPreconditionFailed   = NewError(http.StatusPreconditionFailed, "precondition failed")
+	ErrUnsupportedMediaType = NewError(http.StatusUnsupportedMediaType, "unsupported media type")
+	ErrPreconditionRequired = NewError(http.StatusPreconditionRequired, "precondition required")
+	ErrTooManyRequests      = NewError(http.StatusTooManyRequests, "too many requests")
+)
+
+// Problem is an RFC 7807 problem details object
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is one failed validation rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Wrap tags a plain error with a status, e.g. problem.Wrap(problem.ErrBadRequest, err)
+func Wrap(status *StatusError, err error) error {
+	return fmt.Errorf("%w: %w", status, err)
+}
+
+// New builds a problem for a status with the request's instance and id filled in
+func New(r *http.Request, status int, detail string) *Problem {
+	return &Problem{
+		Type:      typeOf(status),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestId: middleware.GetReqID(r.Context()),
+	}
+}
+
+// From maps an error onto a problem. Anything not recognised is a 500, its message goes to the log only.
+func From(r *http.Request, err error) *Problem {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		p := New(r, http.StatusUnprocessableEntity, "validation failed")
+		for _, fe := range validationErrors {
+			p.Errors = append(p.Errors, FieldError{
+				Field:   snakeCase(fe.Field()),
+				Rule:    fe.Tag(),
+				Message: message(fe),
+			})
+		}
+		return p
+	}
+
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return New(r, statusError.Status, err.Error())
+	}
+
+	p := New(r, http.StatusInternalServerError, "internal server error")
+	slog.ErrorContext(r.Context(), "internal server error", "error", err, "method", r.Method, "path", r.URL.Path, "request_id", p.RequestId)
+	return p
+}
+
+// Write maps err onto a problem and writes it
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	From(r, err).Write(w)
+}
+
+// WriteStatus writes a problem for a status that has no domain error behind it
+func WriteStatus(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	New(r, status, detail).Write(w)
+}
+
+func (p *Problem) Write(w http.ResponseWriter) {
+	w.Header().Set(headers.ContentType, httpconst.ApplicationProblemJSON)
+	w.WriteHeader(p.Status)
+
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		slog.Error("failed to write problem", "error", err)
+	}
+}
+
+// typeOf derives a stable type URI from the status, e.g. urn:golab:problem:not-found
+func typeOf(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "about:blank"
+	}
+	return typePrefix + strings.ReplaceAll(strings.ToLower(text), " ", "-")
+}
+
+func message(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "notblank":
+		return "must not be blank"
+	case "min":
+		return "must be at least " + fe.Param() + " long"
+	case "max":
+		return "must be at most " + fe.Param() + " long"
+	default:
+		return "failed on '" + fe.Tag() + "'"
+	}
+}
+
+// snakeCase turns Go field names into the json names clients know, ResourceId -> resource_id
+func snakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package problem
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-http-utils/headers"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrom(t *testing.T) {
+	req := require.New(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/player/7?x=1", nil)
+	errThing := NewError(http.StatusNotFound, "thing not found")
+
+	p := From(r, fmt.Errorf("loading: %w", errThing))
+	req.Equal(http.StatusNotFound, p.Status)
+	req.Equal("urn:golab:problem:not-found", p.Type)
+	req.Equal("Not Found", p.Title)
+	req.Equal("loading: thing not found", p.Detail)
+	req.Equal("/api/player/7", p.Instance)
+
+	p = From(r, Wrap(ErrBadRequest, fmt.Errorf("missing ETag")))
+	req.Equal(http.StatusBadRequest, p.Status)
+
+	// internals never reach the client
+	p = From(r, fmt.Errorf("select failed: %w", sql.ErrConnDone))
+	req.Equal(http.StatusInternalServerError, p.Status)
+	req.Equal("internal server error", p.Detail)
+}
+
+func TestFromValidation(t *testing.T) {
+	req := require.New(t)
+
+	type thing struct {
+		ResourceId string `validate:"required"`
+		Name       string `validate:"max=3"`
+	}
+	err := validator.New().Struct(thing{Name: "toolong"})
+	req.Error(err)
+
+	p := From(httptest.NewRequest(http.MethodPost, "/api/thing", nil), err)
+	req.Equal(http.StatusUnprocessableEntity, p.Status)
+	req.Equal([]FieldError{
+		{Field: "resource_id", Rule: "required", Message: "is required"},
+		{Field: "name", Rule: "max", Message: "must be at most 3 long"},
+	}, p.Errors)
+}
+
+func TestWrite(t *testing.T) {
+	req := require.New(t)
+
+	w := httptest.NewRecorder()
+	WriteStatus(w, httptest.NewRequest(http.MethodDelete, "/api/player/trash", nil), http.StatusForbidden, "admins only")
+
+	req.Equal(http.StatusForbidden, w.Code)
+	req.Equal(httpconst.ApplicationProblemJSON, w.Header().Get(headers.ContentType))
+
+	var p Problem
+	req.NoError(json.Unmarshal(w.Body.Bytes(), &p))
+	req.Equal("admins only", p.Detail)
+	req.Equal("urn:golab:problem:forbidden", p.Type)
+}
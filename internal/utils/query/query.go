@@ -0,0 +1,371 @@
+package query
+
+import (
+	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/problem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ParamSort           = "sort"
+	ParamIncludeDeleted = "include_deleted"
+)
+
+var (
+	ErrInvalid   = problem.NewError(http.StatusBadRequest, "invalid query")
+	ErrForbidden = problem.NewError(http.StatusForbidden, "forbidden query")
+)
+
+// params owned by other packages, never treated as filters
+var reserved = []string{paging.ParamPage, paging.ParamLimit, paging.ParamCursor, paging.ParamTotal, ParamSort, ParamIncludeDeleted}
+
+type Kind int
+
+const (
+	String Kind = iota
+	Time
+)
+
+type Op string
+
+const (
+	Eq       Op = "eq"
+	In       Op = "in"
+	Prefix   Op = "prefix"
+	Contains Op = "contains"
+	Lt       Op = "lt"
+	Lte      Op = "lte"
+	Gt       Op = "gt"
+	Gte      Op = "gte"
+)
+
+// aliases read better on timestamps, e.g. last_checkin[before]=...
+var aliases = map[string]Op{
+	"before": Lt,
+	"after":  Gt,
+}
+
+var operators = map[Op]string{
+	Eq:  "=",
+	Lt:  "<",
+	Lte: "<=",
+	Gt:  ">",
+	Gte: ">=",
+}
+
+// nullTime stands in for NULL timestamps so nullable columns can take part in keyset comparisons.
+var nullTime = time.Unix(1, 0)
+
+// Field describes a column callers may filter and/or sort on.
+type Field[T any] struct {
+	Column   string
+	Kind     Kind
+	Nullable bool
+	Ops      []Op
+	Sortable bool
+	Key      func(T) any // value of the field on a row, used to build cursors; string or *time.Time
+}
+
+func (f Field[T]) expr() string {
+	if f.Nullable && f.Kind == Time {
+		return "COALESCE(" + f.Column + ", FROM_UNIXTIME(1))"
+	}
+	return f.Column
+}
+
+// Schema is the whitelist of fields for one resource. Anything not in it is rejected.
+type Schema[T any] struct {
+	Fields      map[string]Field[T]
+	IdColumn    string
+	Id          func(T) uint
+	DefaultSort []Sort
+}
+
+type Filter struct {
+	Field  string
+	Op     Op
+	Values []any
+}
+
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+type Query struct {
+	Filters        []Filter
+	Sort           []Sort
+	IncludeDeleted bool
+	OnlyDeleted    bool // the trash, never parsed from the query string
+}
+
+// Parse reads field[op]=value filters, sort=-a,b and include_deleted from the query string.
+// A bare field=value means eq; in takes a comma separated list.
+func (s Schema[T]) Parse(values url.Values, admin bool) (Query, error) {
+	q := Query{Sort: s.DefaultSort}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys) // stable SQL, friendlier for caching
+
+	for _, key := range keys {
+		if slices.Contains(reserved, key) {
+			continue
+		}
+
+		name, op, err := splitKey(key)
+		if err != nil {
+			return Query{}, err
+		}
+
+		field, ok := s.Fields[name]
+		if !ok {
+			return Query{}, fmt.Errorf("%w: unknown field '%s'", ErrInvalid, name)
+		}
+		if !slices.Contains(field.Ops, op) {
+			return Query{}, fmt.Errorf("%w: operator '%s' is not supported on '%s'", ErrInvalid, op, name)
+		}
+
+		for _, raw := range values[key] {
+			parts := []string{raw}
+			if op == In {
+				parts = strings.Split(raw, ",")
+			}
+
+			filter := Filter{Field: name, Op: op}
+			for _, part := range parts {
+				v, err := parseValue(field.Kind, part)
+				if err != nil {
+					return Query{}, fmt.Errorf("%w: %s: %v", ErrInvalid, key, err)
+				}
+				filter.Values = append(filter.Values, v)
+			}
+			q.Filters = append(q.Filters, filter)
+		}
+	}
+
+	if raw := values.Get(ParamSort); raw != "" {
+		sorts, err := s.parseSort(raw)
+		if err != nil {
+			return Query{}, err
+		}
+		q.Sort = sorts
+	}
+
+	if raw := values.Get(ParamIncludeDeleted); raw != "" {
+		include, err := strconv.ParseBool(raw)
+		if err != nil {
+			return Query{}, fmt.Errorf("%w: invalid %s", ErrInvalid, ParamIncludeDeleted)
+		}
+		if include && !admin {
+			return Query{}, fmt.Errorf("%w: %s is reserved for admins", ErrForbidden, ParamIncludeDeleted)
+		}
+		q.IncludeDeleted = include
+	}
+
+	return q, nil
+}
+
+func (s Schema[T]) parseSort(raw string) ([]Sort, error) {
+	var sorts []Sort
+
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		name := strings.TrimPrefix(strings.TrimPrefix(part, "-"), "+")
+
+		field, ok := s.Fields[name]
+		if !ok || !field.Sortable {
+			return nil, fmt.Errorf("%w: cannot sort on '%s'", ErrInvalid, name)
+		}
+		if slices.ContainsFunc(sorts, func(s Sort) bool { return s.Field == name }) {
+			return nil, fmt.Errorf("%w: duplicate sort on '%s'", ErrInvalid, name)
+		}
+		sorts = append(sorts, Sort{Field: name, Desc: desc})
+	}
+
+	return sorts, nil
+}
+
+func splitKey(key string) (string, Op, error) {
+	open := strings.IndexByte(key, '[')
+	if open < 0 {
+		return key, Eq, nil
+	}
+	if !strings.HasSuffix(key, "]") {
+		return "", "", fmt.Errorf("%w: malformed parameter '%s'", ErrInvalid, key)
+	}
+
+	raw := key[open+1 : len(key)-1]
+	op, ok := aliases[raw]
+	if !ok {
+		op = Op(raw)
+	}
+
+	return key[:open], op, nil
+}
+
+func parseValue(kind Kind, raw string) (any, error) {
+	switch kind {
+	case Time:
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected an RFC 3339 timestamp")
+		}
+		return t, nil
+	default:
+		return raw, nil
+	}
+}
+
+// Where renders the filters as SQL predicates joined by AND. Returns "" when there are none.
+func (s Schema[T]) Where(q Query) (string, []any) {
+	var (
+		preds []string
+		args  []any
+	)
+
+	for _, f := range q.Filters {
+		column := s.Fields[f.Field].Column
+
+		switch f.Op {
+		case In:
+			preds = append(preds, column+" IN (?"+strings.Repeat(", ?", len(f.Values)-1)+")")
+			args = append(args, f.Values...)
+		case Prefix:
+			preds = append(preds, column+" LIKE ?")
+			args = append(args, escapeLike(f.Values[0].(string))+"%")
+		case Contains:
+			preds = append(preds, column+" LIKE ?")
+			args = append(args, "%"+escapeLike(f.Values[0].(string))+"%")
+		default:
+			preds = append(preds, column+" "+operators[f.Op]+" ?")
+			args = append(args, f.Values[0])
+		}
+	}
+
+	return strings.Join(preds, " AND "), args
+}
+
+// OrderBy renders the ORDER BY list, with the id as the final tie-breaker. Backward flips every direction.
+func (s Schema[T]) OrderBy(q Query, backward bool) string {
+	terms := make([]string, 0, len(q.Sort)+1)
+
+	for _, sort := range q.Sort {
+		terms = append(terms, s.Fields[sort.Field].expr()+direction(sort.Desc != backward))
+	}
+	terms = append(terms, s.IdColumn+direction(backward))
+
+	return strings.Join(terms, ", ")
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+// Keyset renders the predicate selecting the rows after (or before, when walking backwards) the cursor.
+func (s Schema[T]) Keyset(q Query, c *paging.Cursor) (string, []any, error) {
+	if err := s.Check(q, c); err != nil {
+		return "", nil, err
+	}
+
+	type key struct {
+		expr string
+		desc bool
+		val  any
+	}
+
+	backward := c.Dir == paging.Prev
+	keys := make([]key, 0, len(q.Sort)+1)
+
+	for i, sort := range q.Sort {
+		field := s.Fields[sort.Field]
+		v, err := cursorValue(field, c.Keys[i])
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, key{expr: field.expr(), desc: sort.Desc, val: v})
+	}
+	keys = append(keys, key{expr: s.IdColumn, val: c.Id})
+
+	// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+	var (
+		ors  []string
+		args []any
+	)
+	for i, k := range keys {
+		var ands []string
+		for _, prev := range keys[:i] {
+			ands = append(ands, prev.expr+" = ?")
+			args = append(args, prev.val)
+		}
+
+		op := " > ?"
+		if k.desc != backward {
+			op = " < ?"
+		}
+		ands = append(ands, k.expr+op)
+		args = append(args, k.val)
+
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return "(" + strings.Join(ors, " OR ") + ")", args, nil
+}
+
+// Check makes sure a cursor was issued for the same sort as the query.
+func (s Schema[T]) Check(q Query, c *paging.Cursor) error {
+	if c != nil && len(c.Keys) != len(q.Sort) {
+		return fmt.Errorf("%w: cursor does not match the sort order", ErrInvalid)
+	}
+	return nil
+}
+
+// Cursor builds the keyset cursor of a row for the query's sort.
+func (s Schema[T]) Cursor(q Query, row T) paging.Cursor {
+	keys := make([]string, len(q.Sort))
+
+	for i, sort := range q.Sort {
+		switch v := s.Fields[sort.Field].Key(row).(type) {
+		case *time.Time:
+			if v != nil {
+				keys[i] = v.Format(time.RFC3339Nano)
+			}
+		case string:
+			keys[i] = v
+		default:
+			keys[i] = fmt.Sprint(v)
+		}
+	}
+
+	return paging.Cursor{Keys: keys, Id: s.Id(row)}
+}
+
+func cursorValue[T any](field Field[T], raw string) (any, error) {
+	if field.Kind != Time {
+		return raw, nil
+	}
+	if raw == "" {
+		return nullTime, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid cursor", ErrInvalid)
+	}
+	return t, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"Go-lab/internal/utils/paging"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type row struct {
+	id   uint
+	name string
+	seen *time.Time
+}
+
+var schema = Schema[row]{
+	Fields: map[string]Field[row]{
+		"name": {Column: "name", Kind: String, Ops: []Op{Eq, Prefix, Contains, In}, Sortable: true, Key: func(r row) any { return r.name }},
+		"seen": {Column: "seen", Kind: Time, Nullable: true, Ops: []Op{Lt, Gt}, Sortable: true, Key: func(r row) any { return r.seen }},
+	},
+	IdColumn:    "id",
+	Id:          func(r row) uint { return r.id },
+	DefaultSort: []Sort{{Field: "name"}},
+}
+
+func TestParse(t *testing.T) {
+	req := require.New(t)
+
+	q, err := schema.Parse(url.Values{
+		"name[prefix]": {"Pl%"},
+		"seen[before]": {"2025-01-02T03:04:05Z"},
+		"sort":         {"-seen,name"},
+		"limit":        {"10"},
+	}, false)
+	req.NoError(err)
+	req.Equal([]Sort{{Field: "seen", Desc: true}, {Field: "name"}}, q.Sort)
+
+	where, args := schema.Where(q)
+	req.Equal("name LIKE ? AND seen < ?", where)
+	req.Equal(`Pl\%%`, args[0])
+	req.Equal(time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), args[1])
+
+	q, err = schema.Parse(url.Values{"name[in]": {"a,b,c"}}, false)
+	req.NoError(err)
+	where, args = schema.Where(q)
+	req.Equal("name IN (?, ?, ?)", where)
+	req.Len(args, 3)
+	req.Equal(schema.DefaultSort, q.Sort)
+}
+
+func TestParseRejects(t *testing.T) {
+	req := require.New(t)
+
+	for _, values := range []url.Values{
+		{"nope": {"x"}},
+		{"name[gt]": {"x"}},
+		{"name[prefix": {"x"}},
+		{"seen[after]": {"yesterday"}},
+		{"sort": {"id"}},
+		{"sort": {"name,-name"}},
+		{"include_deleted": {"maybe"}},
+	} {
+		_, err := schema.Parse(values, true)
+		req.True(errors.Is(err, ErrInvalid), "%v", values)
+	}
+
+	_, err := schema.Parse(url.Values{"include_deleted": {"true"}}, false)
+	req.True(errors.Is(err, ErrForbidden))
+
+	q, err := schema.Parse(url.Values{"include_deleted": {"true"}}, true)
+	req.NoError(err)
+	req.True(q.IncludeDeleted)
+}
+
+func TestKeyset(t *testing.T) {
+	req := require.New(t)
+
+	q := Query{Sort: []Sort{{Field: "seen", Desc: true}, {Field: "name"}}}
+	req.Equal("COALESCE(seen, FROM_UNIXTIME(1)) DESC, name ASC, id ASC", schema.OrderBy(q, false))
+	req.Equal("COALESCE(seen, FROM_UNIXTIME(1)) ASC, name DESC, id DESC", schema.OrderBy(q, true))
+
+	cursor := schema.Cursor(q, row{id: 3, name: "c"})
+	req.Equal([]string{"", "c"}, cursor.Keys)
+	req.Equal(uint(3), cursor.Id)
+
+	cursor.Dir = paging.Next
+	where, args, err := schema.Keyset(q, &cursor)
+	req.NoError(err)
+	req.Equal("((COALESCE(seen, FROM_UNIXTIME(1)) < ?) OR "+
+		"(COALESCE(seen, FROM_UNIXTIME(1)) = ? AND name > ?) OR "+
+		"(COALESCE(seen, FROM_UNIXTIME(1)) = ? AND name = ? AND id > ?))", where)
+	req.Len(args, 6)
+	req.Equal(nullTime, args[0])
+
+	_, _, err = schema.Keyset(Query{Sort: schema.DefaultSort}, &cursor)
+	req.True(errors.Is(err, ErrInvalid))
+}
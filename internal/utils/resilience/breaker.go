@@ -0,0 +1,113 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Closed   State = iota // requests flow
+	Open                  // requests fail fast until the cool down is over
+	HalfOpen              // one probe decides between Closed and Open
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is a consecutive failure circuit breaker
+type Breaker struct {
+	threshold int
+	openFor   time.Duration
+	onChange  func(from, to State)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewBreaker(threshold int, openFor time.Duration, onChange func(from, to State)) *Breaker {
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if onChange == nil {
+		onChange = func(from, to State) {}
+	}
+	return &Breaker{
+		threshold: threshold,
+		openFor:   openFor,
+		onChange:  onChange,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a request may go through. Every allowed request must end in Success or Failure.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.openFor {
+			return false
+		}
+		b.set(HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	if b.state != Closed {
+		b.set(Closed)
+	}
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == HalfOpen || (b.state == Closed && b.failures >= b.threshold) {
+		b.openedAt = b.now()
+		b.set(Open)
+	}
+}
+
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) set(to State) {
+	from := b.state
+	b.state = to
+	b.onChange(from, to)
+}
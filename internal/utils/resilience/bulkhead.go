@@ -0,0 +1,51 @@
+package resilience
+
+import (
+	"context"
+	"time"
+)
+
+// Bulkhead caps the number of calls in flight, so a slow upstream cannot tie up every goroutine
+type Bulkhead struct {
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func NewBulkhead(size int, wait time.Duration) *Bulkhead {
+	if size <= 0 {
+		size = 1
+	}
+	return &Bulkhead{
+		slots: make(chan struct{}, size),
+		wait:  wait,
+	}
+}
+
+// Acquire waits up to the configured time for a free slot. Release it when the call is done.
+func (b *Bulkhead) Acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(b.wait)
+	defer timer.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Bulkhead) Release() {
+	<-b.slots
+}
+
+func (b *Bulkhead) InFlight() int {
+	return len(b.slots)
+}
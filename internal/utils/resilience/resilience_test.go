@@ -0,0 +1,138 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	req := require.New(t)
+
+	now := time.Now()
+	var changes []string
+	b := NewBreaker(2, time.Minute, func(from, to State) { changes = append(changes, from.String()+">"+to.String()) })
+	b.now = func() time.Time { return now }
+
+	req.True(b.Allow())
+	b.Failure()
+	req.Equal(Closed, b.State())
+	req.True(b.Allow())
+	b.Failure()
+	req.Equal(Open, b.State())
+	req.False(b.Allow())
+
+	now = now.Add(time.Minute)
+	req.True(b.Allow()) // the probe
+	req.Equal(HalfOpen, b.State())
+	req.False(b.Allow()) // one probe at a time
+	b.Failure()
+	req.Equal(Open, b.State())
+
+	now = now.Add(time.Minute)
+	req.True(b.Allow())
+	b.Success()
+	req.Equal(Closed, b.State())
+
+	req.Equal([]string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}, changes)
+}
+
+func TestBulkhead(t *testing.T) {
+	req := require.New(t)
+
+	b := NewBulkhead(1, 10*time.Millisecond)
+	req.NoError(b.Acquire(context.Background()))
+	req.ErrorIs(b.Acquire(context.Background()), ErrBulkheadFull)
+	b.Release()
+	req.NoError(b.Acquire(context.Background()))
+	req.Equal(1, b.InFlight())
+}
+
+func TestTransportRetries(t *testing.T) {
+	req := require.New(t)
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	transport := NewTransport(t.Name(), nil, DefaultOptions())
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	client := &http.Client{Transport: transport}
+
+	// idempotent with a body, replayed on each attempt
+	r, _ := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("hello"))
+	resp, err := client.Do(r)
+	req.NoError(err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	req.Equal("hello", string(body))
+	req.Equal(int32(3), calls.Load())
+	req.Equal([]time.Duration{time.Second, time.Second}, delays)
+
+	// POST is not retried
+	calls.Store(0)
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("x"))
+	req.NoError(err)
+	resp.Body.Close()
+	req.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	req.Equal(int32(1), calls.Load())
+
+	// unless it carries an Idempotency-Key
+	calls.Store(0)
+	r, _ = http.NewRequest(http.MethodPost, server.URL, strings.NewReader("x"))
+	r.Header.Set("Idempotency-Key", "abc")
+	resp, err = client.Do(r)
+	req.NoError(err)
+	resp.Body.Close()
+	req.Equal(http.StatusOK, resp.StatusCode)
+	req.Equal(0, transport.bulkhead.InFlight())
+}
+
+func TestTransportOpensBreaker(t *testing.T) {
+	req := require.New(t)
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	opts := DefaultOptions()
+	opts.FailureThreshold = 2
+	transport := NewTransport(t.Name(), nil, opts)
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		req.NoError(err)
+		resp.Body.Close()
+	}
+
+	_, err := client.Get(server.URL)
+	req.True(errors.Is(err, ErrCircuitOpen))
+	req.Equal(int32(2), calls.Load())
+	for _, state := range transport.States() {
+		req.Equal(Open, state)
+	}
+}
@@ -0,0 +1,298 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen  = errors.New("circuit breaker open")
+	ErrBulkheadFull = errors.New("too many calls in flight")
+)
+
+type Options struct {
+	MaxAttempts    int           // first call included
+	BaseDelay      time.Duration // backoff before the second attempt, doubled after that, with full jitter
+	MaxDelay       time.Duration // cap of the backoff; a longer Retry-After ends the retries
+	AttemptTimeout time.Duration // 0 leaves it to the caller's context
+
+	MaxConcurrent int           // bulkhead size, shared by all hosts
+	QueueTimeout  time.Duration // how long a call waits for a bulkhead slot
+
+	FailureThreshold int           // consecutive failures that open the breaker of a host
+	OpenFor          time.Duration // cool down before the half-open probe
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxAttempts:      3,
+		BaseDelay:        200 * time.Millisecond,
+		MaxDelay:         5 * time.Second,
+		AttemptTimeout:   5 * time.Second,
+		MaxConcurrent:    20,
+		QueueTimeout:     time.Second,
+		FailureThreshold: 5,
+		OpenFor:          30 * time.Second,
+	}
+}
+
+// Transport is an http.RoundTripper adding a bulkhead, a circuit breaker per host and retries to another one.
+// Only idempotent requests are retried (GET, HEAD, OPTIONS, TRACE, PUT, DELETE or anything with an
+// Idempotency-Key), on network errors, 429, 502, 503 and 504, honouring Retry-After.
+// Counters and breaker states are published with expvar under "outbound.<name>".
+type Transport struct {
+	name     string
+	base     http.RoundTripper
+	opts     Options
+	bulkhead *Bulkhead
+	metrics  *expvar.Map
+	sleep    func(ctx context.Context, d time.Duration) error
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewTransport(name string, base http.RoundTripper, opts Options) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+
+	return &Transport{
+		name:     name,
+		base:     base,
+		opts:     opts,
+		bulkhead: NewBulkhead(opts.MaxConcurrent, opts.QueueTimeout),
+		metrics:  metrics(name),
+		sleep:    sleep,
+		breakers: map[string]*Breaker{},
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	breaker := t.breaker(host)
+	retryable := idempotent(req)
+
+	for attempt := 1; ; attempt++ {
+		t.metrics.Add(host+".requests", 1)
+
+		resp, err := t.attempt(req, breaker)
+
+		if !retryable || attempt >= t.opts.MaxAttempts || !transient(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		delay := backoff(t.opts.BaseDelay, t.opts.MaxDelay, attempt)
+		if wait, ok := retryAfter(resp); ok {
+			if wait > t.opts.MaxDelay {
+				return resp, err // the upstream asks for more patience than we have
+			}
+			delay = wait
+		}
+
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err // cannot replay the body
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+
+		t.metrics.Add(host+".retries", 1)
+		slog.InfoContext(req.Context(), "retrying outbound call", "client", t.name, "method", req.Method, "url", req.URL.Redacted(),
+			"attempt", attempt+1, "delay", delay, "status", status(resp), "error", err)
+
+		if err := t.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) attempt(req *http.Request, breaker *Breaker) (*http.Response, error) {
+	host := req.URL.Host
+
+	if err := t.bulkhead.Acquire(req.Context()); err != nil {
+		if errors.Is(err, ErrBulkheadFull) {
+			t.metrics.Add(host+".rejected_bulkhead", 1)
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+		return nil, err
+	}
+
+	if !breaker.Allow() {
+		t.bulkhead.Release()
+		t.metrics.Add(host+".rejected_open", 1)
+		return nil, fmt.Errorf("%s: %w", host, ErrCircuitOpen)
+	}
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if t.opts.AttemptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.opts.AttemptTimeout)
+	}
+	done := func() {
+		cancel()
+		t.bulkhead.Release()
+	}
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		t.metrics.Add(host+".failures", 1)
+		breaker.Failure()
+	} else {
+		breaker.Success()
+	}
+
+	if err != nil {
+		done()
+		return nil, err
+	}
+
+	// the slot and the attempt context live until the body is closed
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: done}
+	return resp, nil
+}
+
+func (t *Transport) breaker(host string) *Breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b, ok := t.breakers[host]; ok {
+		return b
+	}
+
+	b := NewBreaker(t.opts.FailureThreshold, t.opts.OpenFor, func(from, to State) {
+		slog.Warn("circuit breaker state changed", "client", t.name, "host", host, "from", from.String(), "to", to.String())
+	})
+	t.breakers[host] = b
+	t.metrics.Set(host+".state", expvar.Func(func() any { return b.State().String() }))
+	return b
+}
+
+// States reports the breaker state per host
+func (t *Transport) States() map[string]State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	states := make(map[string]State, len(t.breakers))
+	for host, b := range t.breakers {
+		states[host] = b.State()
+	}
+	return states
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+var metricsMu sync.Mutex
+
+func metrics(name string) *expvar.Map {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	key := "outbound." + name
+	if m, ok := expvar.Get(key).(*expvar.Map); ok {
+		return m
+	}
+	return expvar.NewMap(key)
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return req.Header.Get("Idempotency-Key") != ""
+	}
+}
+
+func transient(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrBulkheadFull) && !errors.Is(err, ErrCircuitOpen)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff is exponential with full jitter: a random delay in [0, min(ceiling, base * 2^(attempt-1))]
+func backoff(base, ceiling time.Duration, attempt int) time.Duration {
+	d := base << (attempt - 1)
+	if d <= 0 || d > ceiling {
+		d = ceiling
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// retryAfter reads a Retry-After of delay-seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(h); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(h); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+func status(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
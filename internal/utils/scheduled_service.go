@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"Go-lab/internal/utils/tracecontext"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ScheduledService is a Service that runs a task straight away and then every interval until stopped.
+// A failing run is logged and retried on the next tick.
+type ScheduledService struct {
+	name     string
+	interval time.Duration
+	task     func(ctx context.Context) error
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewScheduledService(name string, interval time.Duration, task func(ctx context.Context) error) *ScheduledService {
+	if interval <= 0 {
+		panic("scheduled service '" + name + "' needs a positive interval")
+	}
+	return &ScheduledService{
+		name:     name,
+		interval: interval,
+		task:     task,
+	}
+}
+
+func (s *ScheduledService) Name() string {
+	return s.name
+}
+
+func (s *ScheduledService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go s.loop(ctx, s.done)
+}
+
+func (s *ScheduledService) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done // let the current run finish
+}
+
+func (s *ScheduledService) IsRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.cancel != nil
+}
+
+func (s *ScheduledService) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ScheduledService) run(ctx context.Context) {
+	// a trace per run ties its logs, audit rows and remote calls together
+	ctx = tracecontext.NewContext(ctx, tracecontext.New())
+
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "scheduled service panicked", "service", s.name, "panic", r)
+		}
+	}()
+
+	if err := s.task(ctx); err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "scheduled service run failed", "service", s.name, "error", err)
+	}
+}
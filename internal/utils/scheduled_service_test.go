@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduledService(t *testing.T) {
+	req := require.New(t)
+
+	var runs atomic.Int32
+	s := NewScheduledService("test", 10*time.Millisecond, func(ctx context.Context) error {
+		runs.Add(1)
+		return errors.New("failing runs are retried")
+	})
+
+	registry := NewServiceRegistry()
+	registry.Register(s)
+
+	req.False(s.IsRunning())
+	registry.StartAll()
+	req.True(s.IsRunning())
+
+	req.Eventually(func() bool { return runs.Load() >= 3 }, time.Second, 5*time.Millisecond)
+
+	registry.StopAll()
+	req.False(s.IsRunning())
+
+	stopped := runs.Load()
+	time.Sleep(30 * time.Millisecond)
+	req.Equal(stopped, runs.Load())
+
+	// restartable
+	s.Start()
+	req.Eventually(func() bool { return runs.Load() > stopped }, time.Second, 5*time.Millisecond)
+	s.Stop()
+	s.Stop()
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 )
 
+// AdminUserID is the user the db scripts and system jobs run as
+const AdminUserID = 0
+
 // Define context keys to avoid collisions (use unexported type or package-private string)
 type contextKey string
 
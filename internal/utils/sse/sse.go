@@ -0,0 +1,262 @@
+package sse
+
+import (
+	"Go-lab/internal/utils/problem"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-http-utils/headers"
+)
+
+const (
+	ContentType = "text/event-stream"
+
+	headerLastEventId = "Last-Event-ID"
+	queryLastEventId  = "last_event_id" // EventSource cannot set headers on the first connect
+	clientQueue       = 64
+	retryMillis       = 3000
+)
+
+// Event is one message of the stream, Data is JSON
+type Event struct {
+	Id   uint64
+	Type string
+	Data []byte
+}
+
+// Broker fans events out to Server-Sent Events clients and keeps the latest ones for clients that reconnect
+// with a Last-Event-ID. Ids start at the start time in microseconds, so they keep increasing across restarts and
+// an id from before a restart is recognised as too old: such a client gets a "reset" event and should refetch.
+type Broker struct {
+	heartbeat  time.Duration
+	size       int
+	maxClients int
+
+	mu      sync.Mutex
+	lastId  uint64
+	buffer  []Event // oldest first, at most size
+	clients map[chan Event]struct{}
+	done    chan struct{}
+}
+
+// NewBroker keeps the last size events for resuming clients. Streams are long-lived and bypass the request
+// throttle, so the broker caps them at maxClients itself.
+func NewBroker(size, maxClients int, heartbeat time.Duration) *Broker {
+	if size <= 0 {
+		size = 1
+	}
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	return &Broker{
+		heartbeat:  heartbeat,
+		size:       size,
+		maxClients: maxClients,
+		lastId:     uint64(time.Now().UnixMicro()),
+		clients:    map[chan Event]struct{}{},
+		done:       make(chan struct{}),
+	}
+}
+
+// Accepts tells whether the request asks for an event stream
+func Accepts(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(headers.Accept), ContentType)
+}
+
+// Publish sends the event to every client. A client too slow to keep up is dropped, it reconnects and
+// catches up from the buffer.
+func (b *Broker) Publish(eventType string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	e := Event{Id: b.lastId, Type: eventType, Data: data}
+
+	if len(b.buffer) == b.size {
+		copy(b.buffer, b.buffer[1:])
+		b.buffer = b.buffer[:b.size-1]
+	}
+	b.buffer = append(b.buffer, e)
+
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default:
+			delete(b.clients, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// Close ends every stream, for the server shutdown which does not wait for them otherwise
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+}
+
+// subscribe registers a client and returns what it missed since lastId; resumed is false when
+// the buffer no longer reaches back that far. The channel is nil when there are too many clients.
+func (b *Broker) subscribe(lastId *uint64) (ch chan Event, missed []Event, resumed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxClients > 0 && len(b.clients) >= b.maxClients {
+		return nil, nil, false
+	}
+
+	ch = make(chan Event, clientQueue)
+	b.clients[ch] = struct{}{}
+
+	if lastId == nil {
+		return ch, nil, true
+	}
+
+	first := b.lastId + 1
+	if len(b.buffer) > 0 {
+		first = b.buffer[0].Id
+	}
+	if *lastId+1 < first || *lastId > b.lastId {
+		return ch, nil, false
+	}
+
+	for _, e := range b.buffer {
+		if e.Id > *lastId {
+			missed = append(missed, e)
+		}
+	}
+	return ch, missed, true
+}
+
+func (b *Broker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.clients[ch]; ok {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// ServeHTTP streams the events until the client goes away or the broker is closed
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// the server's WriteTimeout would cut the stream
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.WarnContext(r.Context(), "sse: cannot clear the write deadline", "error", err)
+	}
+
+	lastId, err := parseLastEventId(r)
+	if err != nil {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "invalid Last-Event-ID")
+		return
+	}
+
+	ch, missed, resumed := b.subscribe(lastId)
+	if ch == nil {
+		w.Header().Set(headers.RetryAfter, strconv.Itoa(retryMillis/1000))
+		problem.WriteStatus(w, r, http.StatusServiceUnavailable, "too many event streams")
+		return
+	}
+	defer b.unsubscribe(ch)
+
+	w.Header().Set(headers.ContentType, ContentType)
+	w.Header().Set(headers.CacheControl, "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+
+	if _, err = fmt.Fprintf(w, "retry: %d\n\n", retryMillis); err != nil {
+		return
+	}
+	if !resumed {
+		if err = writeEvent(w, Event{Type: "reset", Data: []byte("{}")}); err != nil {
+			return
+		}
+	}
+	for _, e := range missed {
+		if err = writeEvent(w, e); err != nil {
+			return
+		}
+	}
+	if err = rc.Flush(); err != nil {
+		slog.WarnContext(r.Context(), "sse: streaming not supported", "error", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(b.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return // too slow, the client reconnects with its Last-Event-ID
+			}
+			err = writeEvent(w, e)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e Event) error {
+	var sb strings.Builder
+	if e.Id != 0 {
+		sb.WriteString("id: " + strconv.FormatUint(e.Id, 10) + "\n")
+	}
+	if e.Type != "" {
+		sb.WriteString("event: " + e.Type + "\n")
+	}
+	// JSON has no raw newlines, one data line is enough
+	sb.WriteString("data: ")
+	sb.Write(e.Data)
+	sb.WriteString("\n\n")
+
+	_, err := w.Write([]byte(sb.String()))
+	return err
+}
+
+func parseLastEventId(r *http.Request) (*uint64, error) {
+	s := r.Header.Get(headerLastEventId)
+	if s == "" {
+		s = r.URL.Query().Get(queryLastEventId)
+	}
+	if s == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
@@ -0,0 +1,164 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-http-utils/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeResume(t *testing.T) {
+	req := require.New(t)
+
+	b := NewBroker(3, 0, time.Second)
+	start := b.lastId
+	for i := range 5 {
+		req.NoError(b.Publish("tick", i))
+	}
+	req.Len(b.buffer, 3)
+	req.Equal(start+3, b.buffer[0].Id)
+
+	// still in the buffer
+	lastId := start + 3
+	ch, missed, resumed := b.subscribe(&lastId)
+	req.NotNil(ch)
+	req.True(resumed)
+	req.Len(missed, 2)
+	req.Equal(start+4, missed[0].Id)
+	req.Equal(`4`, string(missed[1].Data))
+
+	// up to date
+	lastId = start + 5
+	_, missed, resumed = b.subscribe(&lastId)
+	req.True(resumed)
+	req.Empty(missed)
+
+	// fell out of the buffer
+	lastId = start + 1
+	_, _, resumed = b.subscribe(&lastId)
+	req.False(resumed)
+
+	// from the future, i.e. another process
+	lastId = start + 6
+	_, _, resumed = b.subscribe(&lastId)
+	req.False(resumed)
+}
+
+func TestSlowClientDropped(t *testing.T) {
+	req := require.New(t)
+
+	b := NewBroker(10, 0, time.Second)
+	ch, _, _ := b.subscribe(nil)
+
+	for i := range clientQueue + 1 {
+		req.NoError(b.Publish("tick", i))
+	}
+	req.Empty(b.clients)
+
+	n := 0
+	for range ch {
+		n++
+	}
+	req.Equal(clientQueue, n)
+}
+
+func TestMaxClients(t *testing.T) {
+	req := require.New(t)
+
+	b := NewBroker(10, 1, time.Second)
+	ch, _, _ := b.subscribe(nil)
+	req.NotNil(ch)
+
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events", nil))
+	req.Equal(http.StatusServiceUnavailable, w.Code)
+	req.Equal("3", w.Header().Get(headers.RetryAfter))
+
+	b.unsubscribe(ch)
+	req.Empty(b.clients)
+}
+
+func TestServeHTTP(t *testing.T) {
+	req := require.New(t)
+
+	b := NewBroker(10, 0, time.Hour)
+	req.NoError(b.Publish("tick", map[string]int{"n": 1}))
+	first := b.lastId
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?last_event_id="+strconv.FormatUint(first-1, 10), nil)
+	req.NoError(err)
+	r.Header.Set(headers.Accept, ContentType)
+	req.True(Accepts(r))
+
+	resp, err := http.DefaultClient.Do(r)
+	req.NoError(err)
+	defer resp.Body.Close()
+	req.Equal(http.StatusOK, resp.StatusCode)
+	req.Equal(ContentType, resp.Header.Get(headers.ContentType))
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		req.True(lines.Scan(), "stream ended")
+		return lines.Text()
+	}
+
+	req.Equal("retry: 3000", next())
+	req.Equal("", next())
+	// replayed from the buffer
+	req.Equal("id: "+strconv.FormatUint(first, 10), next())
+	req.Equal("event: tick", next())
+	req.Equal(`data: {"n":1}`, next())
+	req.Equal("", next())
+
+	// live
+	req.Eventually(func() bool {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return len(b.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+	req.NoError(b.Publish("tick", map[string]int{"n": 2}))
+	req.Equal("id: "+strconv.FormatUint(first+1, 10), next())
+	req.Equal("event: tick", next())
+	req.Equal(`data: {"n":2}`, next())
+	req.Equal("", next())
+
+	// shutdown ends the stream
+	b.Close()
+	for lines.Scan() {
+	}
+	req.NoError(lines.Err())
+}
+
+func TestServeHTTPReset(t *testing.T) {
+	req := require.New(t)
+
+	b := NewBroker(10, 0, time.Hour)
+	b.Close()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set(headerLastEventId, "1")
+	b.ServeHTTP(w, r)
+
+	req.Equal(http.StatusOK, w.Code)
+	req.True(strings.Contains(w.Body.String(), "event: reset\ndata: {}\n\n"))
+	req.Empty(b.clients)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/events?last_event_id=x", nil)
+	b.ServeHTTP(w, r)
+	req.Equal(http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,141 @@
+// Package tracecontext carries the W3C Trace Context, https://www.w3.org/TR/trace-context/, through a request:
+// from the traceparent and tracestate headers into the context, the logs, the database session and the
+// outbound calls.
+package tracecontext
+
+import (
+	"Go-lab/internal/utils/session"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderTraceparent = "traceparent"
+	HeaderTracestate  = "tracestate"
+
+	version       = "00"
+	flagSampled   = 0x01
+	maxTracestate = 512
+)
+
+var (
+	zeroTraceId = strings.Repeat("0", 32)
+	zeroSpanId  = strings.Repeat("0", 16)
+)
+
+// TraceContext is one span of a trace. SpanId is the span of whoever holds it, the parent of the spans
+// it starts; State is passed on untouched.
+type TraceContext struct {
+	TraceId string
+	SpanId  string
+	Flags   byte
+	State   string
+}
+
+type contextKey struct{}
+
+// New starts a trace
+func New() TraceContext {
+	return TraceContext{TraceId: randomHex(16), SpanId: randomHex(8), Flags: flagSampled}
+}
+
+// Parse reads the headers, a traceparent that is not valid is no trace at all. Versions after 00 are read as
+// far as 00 goes, as the spec asks.
+func Parse(traceparent, tracestate string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, false
+	}
+	v, traceId, spanId, flags := parts[0], parts[1], parts[2], parts[3]
+
+	switch {
+	case !isHex(v, 2) || v == "ff":
+		return TraceContext{}, false
+	case v == version && len(parts) != 4:
+		return TraceContext{}, false
+	case !isHex(traceId, 32) || traceId == zeroTraceId:
+		return TraceContext{}, false
+	case !isHex(spanId, 16) || spanId == zeroSpanId:
+		return TraceContext{}, false
+	case !isHex(flags, 2):
+		return TraceContext{}, false
+	}
+
+	b, _ := hex.DecodeString(flags)
+	t := TraceContext{TraceId: traceId, SpanId: spanId, Flags: b[0]}
+	if len(tracestate) <= maxTracestate {
+		t.State = strings.TrimSpace(tracestate)
+	}
+	return t, true
+}
+
+// Child is a new span of the same trace
+func (t TraceContext) Child() TraceContext {
+	t.SpanId = randomHex(8)
+	return t
+}
+
+func (t TraceContext) Traceparent() string {
+	return fmt.Sprintf("%s-%s-%s-%02x", version, t.TraceId, t.SpanId, t.Flags)
+}
+
+// NewContext stores the trace, its id also goes where session.TraceIDFromContext finds it
+func NewContext(ctx context.Context, t TraceContext) context.Context {
+	ctx = session.ContextWithTraceID(ctx, t.TraceId)
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+func FromContext(ctx context.Context) (TraceContext, bool) {
+	t, ok := ctx.Value(contextKey{}).(TraceContext)
+	return t, ok
+}
+
+// Inject sets the headers of an outbound call, as a child of the span in ctx. Without a trace nothing is set,
+// the callee starts its own.
+func Inject(ctx context.Context, h http.Header) {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	h.Set(HeaderTraceparent, t.Child().Traceparent())
+	if t.State != "" {
+		h.Set(HeaderTracestate, t.State)
+	}
+}
+
+// Middleware continues the trace of the caller, or starts one, in a span of this request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t, ok := Parse(r.Header.Get(HeaderTraceparent), r.Header.Get(HeaderTracestate))
+		if ok {
+			t = t.Child()
+		} else {
+			t = New()
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), t)))
+	})
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // never fails, see crypto/rand
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,123 @@
+package tracecontext
+
+import (
+	"Go-lab/internal/utils/session"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const example = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParse(t *testing.T) {
+	req := require.New(t)
+
+	tc, ok := Parse(example, "congo=t61rcWkgMzE")
+	req.True(ok)
+	req.Equal(TraceContext{TraceId: "4bf92f3577b34da6a3ce929d0e0e4736", SpanId: "00f067aa0ba902b7", Flags: 1, State: "congo=t61rcWkgMzE"}, tc)
+	req.Equal(example, tc.Traceparent())
+
+	// a later version may have more fields
+	_, ok = Parse("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-holds", "")
+	req.True(ok)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-x1",
+	} {
+		_, ok = Parse(invalid, "")
+		req.False(ok, invalid)
+	}
+}
+
+func TestNewChild(t *testing.T) {
+	req := require.New(t)
+
+	tc := New()
+	_, ok := Parse(tc.Traceparent(), "")
+	req.True(ok)
+	req.NotEqual(New().TraceId, tc.TraceId)
+
+	child := tc.Child()
+	req.Equal(tc.TraceId, child.TraceId)
+	req.NotEqual(tc.SpanId, child.SpanId)
+}
+
+func TestMiddlewareInject(t *testing.T) {
+	req := require.New(t)
+
+	var got TraceContext
+	outbound := http.Header{}
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		got, ok = FromContext(r.Context())
+		req.True(ok)
+		id, ok := session.TraceIDFromContext(r.Context())
+		req.True(ok)
+		req.Equal(got.TraceId, id)
+
+		Inject(r.Context(), outbound)
+	}))
+
+	// the trace of the caller goes on
+	r := httptest.NewRequest(http.MethodGet, "/player", nil)
+	r.Header.Set(HeaderTraceparent, example)
+	r.Header.Set(HeaderTracestate, "congo=t61rcWkgMzE")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	req.Equal("4bf92f3577b34da6a3ce929d0e0e4736", got.TraceId)
+	req.NotEqual("00f067aa0ba902b7", got.SpanId)
+
+	sent, ok := Parse(outbound.Get(HeaderTraceparent), outbound.Get(HeaderTracestate))
+	req.True(ok)
+	req.Equal(got.TraceId, sent.TraceId)
+	req.NotEqual(got.SpanId, sent.SpanId)
+	req.Equal("congo=t61rcWkgMzE", sent.State)
+
+	// a broken one starts a new trace
+	r = httptest.NewRequest(http.MethodGet, "/player", nil)
+	r.Header.Set(HeaderTraceparent, "00-junk")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	req.Len(got.TraceId, 32)
+	req.NotEqual("4bf92f3577b34da6a3ce929d0e0e4736", got.TraceId)
+
+	// nothing to pass on
+	outbound = http.Header{}
+	Inject(context.Background(), outbound)
+	req.Empty(outbound)
+}
+
+func TestLogHandler(t *testing.T) {
+	req := require.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(NewLogHandler(slog.NewJSONHandler(&buf, nil))).With("service", "test")
+
+	tc := New()
+	logger.InfoContext(NewContext(context.Background(), tc), "traced")
+	logger.Info("untraced")
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	req.Len(lines, 2)
+
+	var record map[string]any
+	req.NoError(json.Unmarshal(lines[0], &record))
+	req.Equal(tc.TraceId, record["trace_id"])
+	req.Equal("test", record["service"])
+
+	record = nil
+	req.NoError(json.Unmarshal(lines[1], &record))
+	req.NotContains(record, "trace_id")
+}
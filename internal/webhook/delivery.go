@@ -0,0 +1,30 @@
+package webhook
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/session"
+	"context"
+	"log/slog"
+)
+
+// NewDeliveryService sends the due webhook deliveries on a schedule, batch after batch until none are left
+func NewDeliveryService(service *Service, cfg config.WebhookConfig) utils.Service {
+	return utils.NewScheduledService("webhook-delivery", cfg.Interval, func(ctx context.Context) error {
+		ctx = session.ContextWithUserID(ctx, session.AdminUserID)
+
+		for ctx.Err() == nil {
+			n, err := service.Deliver(ctx)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				slog.DebugContext(ctx, "webhook deliveries sent", "count", n)
+			}
+			if n < batchSize {
+				return nil
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"Go-lab/internal/utils/validate"
+	"encoding/json"
+	"time"
+)
+
+type DTO struct {
+	Id          *uint      `json:"id"`
+	Url         string     `json:"url"`
+	Events      []string   `json:"events"`
+	Description *string    `json:"description"`
+	Active      bool       `json:"active"`
+	Secret      string     `json:"secret,omitempty"` // only in the answer to the POST, keep it safe
+	CreatedAt   *time.Time `json:"created_at"`
+	CreatedBy   *uint      `json:"created_by"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+	UpdatedBy   *uint      `json:"updated_by"`
+}
+
+// SubscriptionDto is the body of POST and PUT /webhook, the secret is always generated
+type SubscriptionDto struct {
+	Url         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description *string  `json:"description"`
+	Active      *bool    `json:"active"` // defaults to true
+}
+
+type DeliveryDTO struct {
+	Id             *uint           `json:"id"`
+	EventId        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         Status          `json:"status"`
+	Attempts       uint            `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      *time.Time      `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	History        []AttemptDTO    `json:"attempts_history,omitempty"` // only for a single delivery
+}
+
+type AttemptDTO struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  *int      `json:"status_code"`
+	Error       *string   `json:"error"`
+	DurationMs  uint      `json:"duration_ms"`
+}
+
+func ToDTO(s *Subscription) (*DTO, error) {
+	if err := validate.Get().Var(s, "required"); err != nil {
+		return nil, err
+	}
+
+	return &DTO{
+		Id:          s.Id,
+		Url:         s.Url,
+		Events:      s.Events,
+		Description: s.Description,
+		Active:      s.Active,
+		CreatedAt:   s.CreatedAt,
+		CreatedBy:   s.CreatedBy,
+		UpdatedAt:   s.UpdatedAt,
+		UpdatedBy:   s.UpdatedBy,
+	}, nil
+}
+
+func ToDTOs(subscriptions []Subscription) ([]DTO, error) {
+	res := make([]DTO, len(subscriptions))
+	for i := range subscriptions {
+		dto, err := ToDTO(&subscriptions[i])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = *dto
+	}
+	return res, nil
+}
+
+func ToEntity(dto SubscriptionDto) (*Subscription, error) {
+	s := &Subscription{
+		Url:         dto.Url,
+		Events:      dto.Events,
+		Description: dto.Description,
+		Active:      dto.Active == nil || *dto.Active,
+	}
+
+	// the secret is set by the service
+	if err := validate.Get().StructExcept(s, "Secret"); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func ToDeliveryDTO(d *Delivery, attempts []Attempt) DeliveryDTO {
+	dto := DeliveryDTO{
+		Id:             d.Id,
+		EventId:        d.EventId,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+	}
+	if d.Status == Pending {
+		dto.NextAttemptAt = d.NextAttemptAt
+	}
+	for _, a := range attempts {
+		dto.History = append(dto.History, AttemptDTO{
+			AttemptedAt: a.AttemptedAt,
+			StatusCode:  a.StatusCode,
+			Error:       a.Error,
+			DurationMs:  a.DurationMs,
+		})
+	}
+	return dto
+}
+
+func ToDeliveryDTOs(deliveries []Delivery) ([]DeliveryDTO, error) {
+	res := make([]DeliveryDTO, len(deliveries))
+	for i := range deliveries {
+		res[i] = ToDeliveryDTO(&deliveries[i], nil)
+	}
+	return res, nil
+}
@@ -0,0 +1,294 @@
+package webhook
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/utils/httpconst"
+	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-http-utils/headers"
+)
+
+const headerLink = "Link"
+
+type Handler struct {
+	service *Service
+	cfg     config.AppConfig
+}
+
+func NewHandler(service *Service, cfg config.AppConfig) *Handler {
+	if err := validate.Get().Var(service, "required"); err != nil {
+		panic(err)
+	}
+	return &Handler{
+		service: service,
+		cfg:     cfg,
+	}
+}
+
+func (h Handler) List(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	subscriptions, err := h.service.FindAll(ctx)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dtos, err := ToDTOs(subscriptions)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dtos)
+}
+
+func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	subscription, err := h.service.FindById(ctx, id)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	if etag.HandleConditionalGet(w, r, subscription.UpdatedAt) {
+		return
+	}
+
+	dto, err := ToDTO(subscription)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dto)
+}
+
+// Create answers with the subscription including its signing secret, which is not shown again
+func (h Handler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	var body SubscriptionDto
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "bad JSON format")
+		return
+	}
+
+	subscription, err := ToEntity(body)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	created, err := h.service.Create(ctx, subscription)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dto, err := ToDTO(created)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	dto.Secret = created.Secret
+
+	w.Header().Set(headers.CacheControl, "no-store")
+	w.Header().Set(headers.ETag, etag.MakeWeakETag(created.UpdatedAt))
+	writeJSON(w, http.StatusCreated, dto)
+}
+
+func (h Handler) Update(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	version, err := etag.Version(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	var body SubscriptionDto
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "bad JSON format")
+		return
+	}
+
+	subscription, err := ToEntity(body)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+	subscription.Id = &id
+
+	updated, err := h.service.Update(ctx, subscription, version)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	dto, err := ToDTO(updated)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headers.ETag, etag.MakeWeakETag(updated.UpdatedAt))
+	writeJSON(w, http.StatusOK, dto)
+}
+
+func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	version, err := etag.Version(r)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	if err = h.service.Delete(ctx, id, version); err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+// Deliveries pages through the deliveries of a subscription, newest first unless sorted otherwise.
+// Filters: status, status[in], event, event[in], created_at[after|before|gte|lte].
+func (h Handler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	p, err := paging.Parse(r.URL.Query())
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	q, err := deliverySchema.Parse(r.URL.Query(), false)
+	if err == nil {
+		err = deliverySchema.Check(q, p.Cursor)
+	}
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	deliveries, err := h.service.FindDeliveries(ctx, id, p, q)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	page, err := paging.Map(*deliveries, ToDeliveryDTOs)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set(headerLink, paging.Links(*r.URL, p, page))
+	writeJSON(w, http.StatusOK, page)
+}
+
+// Delivery shows one delivery with the history of its attempts
+func (h Handler) Delivery(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+	deliveryId, err := pathParamId(w, r, "delivery_id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	delivery, attempts, err := h.service.FindDelivery(ctx, id, deliveryId)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ToDeliveryDTO(delivery, attempts))
+}
+
+// Replay sends a failed delivery again, with a fresh set of attempts
+func (h Handler) Replay(w http.ResponseWriter, r *http.Request) {
+	id, err := pathParamId(w, r, "id")
+	if err != nil {
+		return
+	}
+	deliveryId, err := pathParamId(w, r, "delivery_id")
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.TimeoutInSeconds)
+	defer cancel()
+
+	delivery, err := h.service.Replay(ctx, id, deliveryId)
+	if err != nil {
+		problem.Write(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, ToDeliveryDTO(delivery, nil))
+}
+
+func pathParamId(w http.ResponseWriter, r *http.Request, name string) (uint, error) {
+	id, err := strconv.ParseUint(chi.URLParam(r, name), 10, 32)
+	if err != nil {
+		problem.WriteStatus(w, r, http.StatusBadRequest, "invalid "+name)
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set(headers.ContentType, httpconst.ApplicationJSON)
+	w.WriteHeader(status)
+
+	if v == nil {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
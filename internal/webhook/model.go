@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"Go-lab/internal/audit"
+	"Go-lab/internal/utils"
+	"Go-lab/internal/utils/problem"
+	"Go-lab/internal/utils/validate"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound         = problem.NewError(http.StatusNotFound, "webhook not found")
+	ErrConflict         = problem.NewError(http.StatusPreconditionFailed, "webhook already modified by another request, please refresh and retry.")
+	ErrDeliveryNotFound = problem.NewError(http.StatusNotFound, "webhook delivery not found")
+	ErrNotReplayable    = problem.NewError(http.StatusConflict, "only failed deliveries can be replayed")
+)
+
+type Status string
+
+const (
+	Pending   Status = "pending"
+	Delivered Status = "delivered"
+	Failed    Status = "failed" // out of attempts, waits for a replay
+)
+
+// Subscription is a partner endpoint and the events it wants. The secret signs every delivery.
+type Subscription struct {
+	audit.Auditable
+	Id          *uint   `db:"id"`
+	Url         string  `db:"url" validate:"required,http_url,max=2000"`
+	Secret      string  `db:"secret" validate:"required"`
+	Events      Events  `db:"events" validate:"required,min=1,dive,oneof=player.created player.updated player.deleted player.checked_in"`
+	Description *string `db:"description" validate:"omitnil,notblank,max=255"`
+	Active      bool    `db:"active"`
+}
+
+func (s *Subscription) Validate() error {
+	return validate.Get().Struct(s)
+}
+
+// String leaves the secret out, subscriptions end up in logs
+func (s *Subscription) String() string {
+	c := *s
+	c.Secret = "***"
+	return utils.ToString(c)
+}
+
+// Events is stored in a SET column, comma separated
+type Events []string
+
+func (e Events) Value() (driver.Value, error) {
+	return strings.Join(e, ","), nil
+}
+
+func (e *Events) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into events", src)
+	}
+
+	*e = nil
+	if s != "" {
+		*e = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Delivery is one event on its way to one subscription
+type Delivery struct {
+	Id             *uint      `db:"id"`
+	SubscriptionId uint       `db:"subscription_id"`
+	EventId        string     `db:"event_id"` // the same for every subscription and every attempt, receivers dedupe on it
+	Event          string     `db:"event"`
+	Payload        []byte     `db:"payload"`
+	Status         Status     `db:"status"`
+	Attempts       uint       `db:"attempts"`
+	NextAttemptAt  *time.Time `db:"next_attempt_at"`
+	LastStatusCode *int       `db:"last_status_code"`
+	LastError      *string    `db:"last_error"`
+	CreatedAt      *time.Time `db:"created_at"`
+	DeliveredAt    *time.Time `db:"delivered_at"`
+}
+
+// Attempt is the record of one POST of a delivery
+type Attempt struct {
+	Id          *uint     `db:"id"`
+	DeliveryId  uint      `db:"delivery_id"`
+	AttemptedAt time.Time `db:"attempted_at"`
+	StatusCode  *int      `db:"status_code"`
+	Error       *string   `db:"error"`
+	DurationMs  uint      `db:"duration_ms"`
+}
+
+// dueDelivery is a claimed delivery along with where to send it
+type dueDelivery struct {
+	Delivery
+	Url    string `db:"url"`
+	Secret string `db:"secret"`
+}
@@ -0,0 +1,36 @@
+package webhook
+
+import (
+	"Go-lab/internal/utils/query"
+)
+
+// deliverySchema whitelists what GET /webhook/{id}/deliveries can filter and sort on.
+var deliverySchema = query.Schema[Delivery]{
+	Fields: map[string]query.Field[Delivery]{
+		"status": {
+			Column: "status",
+			Kind:   query.String,
+			Ops:    []query.Op{query.Eq, query.In},
+		},
+		"event": {
+			Column: "event",
+			Kind:   query.String,
+			Ops:    []query.Op{query.Eq, query.In},
+		},
+		"created_at": {
+			Column:   "created_at",
+			Kind:     query.Time,
+			Ops:      []query.Op{query.Lt, query.Lte, query.Gt, query.Gte},
+			Sortable: true,
+			Key:      func(d Delivery) any { return d.CreatedAt },
+		},
+	},
+	IdColumn: "id",
+	Id: func(d Delivery) uint {
+		if d.Id == nil {
+			return 0
+		}
+		return *d.Id
+	},
+	DefaultSort: []query.Sort{{Field: "created_at", Desc: true}},
+}
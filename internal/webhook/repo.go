@@ -0,0 +1,528 @@
+package webhook
+
+import (
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/query"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Repo struct {
+	tx *sqlx.Tx
+}
+
+func NewRepo(tx *sqlx.Tx) (*Repo, error) {
+	if err := validate.Get().Var(tx, "required"); err != nil {
+		return nil, fmt.Errorf("invalid tx: %w", err)
+	}
+
+	return &Repo{tx: tx}, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Create(ctx context.Context, subscription *Subscription) (*uint, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if err := subscription.Validate(); err != nil {
+		return nil, err
+	}
+
+	res, err := r.tx.NamedExecContext(ctx, `
+		INSERT INTO webhook_subscription
+			(url, secret, events, description, active)
+		VALUES
+			(:url, :secret, :events, :description, :active)`,
+		subscription,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("insert webhook: %w", err)
+	}
+
+	lastInsertedId, err := res.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("insert webhook (cannot get lastInsertId): %w", err)
+	}
+
+	id := uint(lastInsertedId)
+
+	return &id, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindById(ctx context.Context, id uint) (*Subscription, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var subscription Subscription
+
+	if err := r.tx.GetContext(ctx, &subscription, `
+		SELECT
+			id,
+			url,
+			secret,
+			events,
+			description,
+			active,
+			created_at,
+			created_by,
+			updated_at,
+			updated_by
+		FROM
+			webhook_subscription
+		WHERE
+			id = ?
+		AND
+			deleted_at IS NULL`,
+		id,
+	); err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindAll(ctx context.Context) ([]Subscription, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var subscriptions []Subscription
+
+	if err := r.tx.SelectContext(ctx, &subscriptions, `
+		SELECT
+			id,
+			url,
+			secret,
+			events,
+			description,
+			active,
+			created_at,
+			created_by,
+			updated_at,
+			updated_by
+		FROM
+			webhook_subscription
+		WHERE
+			deleted_at IS NULL
+		ORDER BY
+			id`,
+	); err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// Update changes everything but the secret, sql.ErrNoRows when the row is at none of the versions
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Update(ctx context.Context, subscription *Subscription, version etag.Versions) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+	if err := subscription.Validate(); err != nil {
+		return err
+	}
+	if subscription.Id == nil {
+		return fmt.Errorf("id is required")
+	}
+
+	query, args, err := sqlx.Named(`
+		UPDATE
+			webhook_subscription
+		SET
+			url = :url,
+			events = :events,
+			description = :description,
+			active = :active
+		WHERE
+			id = :id
+		AND
+			deleted_at IS NULL`, subscription)
+	if err != nil {
+		return fmt.Errorf("bind update of webhook %d: %w", *subscription.Id, err)
+	}
+
+	match, versions := version.Predicate("updated_at")
+	res, err := r.tx.ExecContext(ctx, query+`
+		AND
+			`+match,
+		append(args, versions...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("update webhook %d: %w", *subscription.Id, err)
+	}
+
+	return affectedOne(res, *subscription.Id)
+}
+
+// Delete Soft Deletes only! Pending deliveries of the subscription are no longer sent.
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Delete(ctx context.Context, id uint, version etag.Versions) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	match, versions := version.Predicate("updated_at")
+	res, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			webhook_subscription
+		SET
+			deleted_at = CURRENT_TIMESTAMP,
+			active = FALSE
+		WHERE
+			id = ?
+		AND
+			deleted_at IS NULL
+		AND
+			`+match,
+		append([]any{id}, versions...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("delete webhook %d: %w", id, err)
+	}
+
+	return affectedOne(res, id)
+}
+
+// Enqueue adds a delivery of the event for every active subscription that wants it, once per event id
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Enqueue(ctx context.Context, eventId, event string, payload []byte) (int64, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery
+			(subscription_id, event_id, event, payload)
+		SELECT
+			id, ?, ?, ?
+		FROM
+			webhook_subscription
+		WHERE
+			active
+		AND
+			deleted_at IS NULL
+		AND
+			FIND_IN_SET(?, events) > 0
+		ON DUPLICATE KEY UPDATE
+			id = id`,
+		eventId, event, payload, event,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("enqueue webhook event %s: %w", eventId, err)
+	}
+
+	return res.RowsAffected()
+}
+
+// ClaimDue locks up to limit pending deliveries that are due and pushes their next attempt lease into the future,
+// so another instance polling at the same time skips them. A crash before RecordAttempt just retries after the lease.
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) ClaimDue(ctx context.Context, limit uint, lease time.Duration) ([]dueDelivery, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var due []dueDelivery
+
+	if err := r.tx.SelectContext(ctx, &due, `
+		SELECT
+			d.id,
+			d.subscription_id,
+			d.event_id,
+			d.event,
+			d.payload,
+			d.status,
+			d.attempts,
+			d.next_attempt_at,
+			d.created_at,
+			s.url,
+			s.secret
+		FROM
+			webhook_delivery d
+		JOIN
+			webhook_subscription s ON s.id = d.subscription_id
+		WHERE
+			d.status = 'pending'
+		AND
+			d.next_attempt_at <= CURRENT_TIMESTAMP(6)
+		AND
+			s.active
+		AND
+			s.deleted_at IS NULL
+		ORDER BY
+			d.next_attempt_at, d.id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`,
+		limit,
+	); err != nil {
+		return nil, err
+	}
+
+	if len(due) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, len(due))
+	for i := range due {
+		ids[i] = *due[i].Id
+	}
+
+	stmt, args, err := sqlx.In(`
+		UPDATE
+			webhook_delivery
+		SET
+			next_attempt_at = CURRENT_TIMESTAMP(6) + INTERVAL ? MICROSECOND
+		WHERE
+			id IN (?)`,
+		lease.Microseconds(), ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.tx.ExecContext(ctx, stmt, args...); err != nil {
+		return nil, fmt.Errorf("claim webhook deliveries: %w", err)
+	}
+
+	return due, nil
+}
+
+// RecordAttempt keeps the attempt and moves the delivery on, to delivered, failed or its next attempt
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) RecordAttempt(ctx context.Context, attempt *Attempt, delivery *Delivery) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+	if delivery.Id == nil {
+		return fmt.Errorf("id is required")
+	}
+
+	if _, err := r.tx.NamedExecContext(ctx, `
+		INSERT INTO webhook_attempt
+			(delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES
+			(:delivery_id, :attempted_at, :status_code, :error, :duration_ms)`,
+		attempt,
+	); err != nil {
+		return fmt.Errorf("insert webhook attempt for delivery %d: %w", *delivery.Id, err)
+	}
+
+	if _, err := r.tx.NamedExecContext(ctx, `
+		UPDATE
+			webhook_delivery
+		SET
+			status = :status,
+			attempts = :attempts,
+			next_attempt_at = :next_attempt_at,
+			last_status_code = :last_status_code,
+			last_error = :last_error,
+			delivered_at = :delivered_at
+		WHERE
+			id = :id`,
+		delivery,
+	); err != nil {
+		return fmt.Errorf("update webhook delivery %d: %w", *delivery.Id, err)
+	}
+
+	return nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindDelivery(ctx context.Context, subscriptionId, id uint) (*Delivery, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var delivery Delivery
+
+	if err := r.tx.GetContext(ctx, &delivery, `
+		SELECT
+			`+deliveryColumns+`
+		FROM
+			webhook_delivery
+		WHERE
+			id = ?
+		AND
+			subscription_id = ?`,
+		id, subscriptionId,
+	); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindDeliveries(ctx context.Context, subscriptionId uint, p paging.Paging, q query.Query) ([]Delivery, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	where, args := deliveryWhereOf(subscriptionId, q)
+
+	if p.IsKeyset() && p.Cursor != nil {
+		keyset, keysetArgs, err := deliverySchema.Keyset(q, p.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		where += " AND " + keyset
+		args = append(args, keysetArgs...)
+	}
+
+	stmt := `
+		SELECT
+			` + deliveryColumns + `
+		FROM
+			webhook_delivery
+		WHERE
+			` + where + `
+		ORDER BY
+			` + deliverySchema.OrderBy(q, p.Backward()) + `
+		LIMIT ?`
+	args = append(args, p.Fetch())
+
+	if !p.IsKeyset() {
+		stmt += " OFFSET ?"
+		args = append(args, p.Offset())
+	}
+
+	var deliveries []Delivery
+
+	if err := r.tx.SelectContext(ctx, &deliveries, stmt, args...); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) CountDeliveries(ctx context.Context, subscriptionId uint, q query.Query) (uint, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	where, args := deliveryWhereOf(subscriptionId, q)
+
+	var count uint
+
+	if err := r.tx.GetContext(ctx, &count, `
+		SELECT
+			COUNT(*)
+		FROM
+			webhook_delivery
+		WHERE
+			`+where,
+		args...,
+	); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) FindAttempts(ctx context.Context, deliveryId uint) ([]Attempt, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var attempts []Attempt
+
+	if err := r.tx.SelectContext(ctx, &attempts, `
+		SELECT
+			id,
+			delivery_id,
+			attempted_at,
+			status_code,
+			error,
+			duration_ms
+		FROM
+			webhook_attempt
+		WHERE
+			delivery_id = ?
+		ORDER BY
+			id`,
+		deliveryId,
+	); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+// Replay puts a failed delivery back in the queue with a fresh set of attempts, sql.ErrNoRows if it is not failed
+//
+//goland:noinspection SqlNoDataSourceInspection,SqlResolve
+func (r *Repo) Replay(ctx context.Context, subscriptionId, id uint) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	res, err := r.tx.ExecContext(ctx, `
+		UPDATE
+			webhook_delivery
+		SET
+			status = 'pending',
+			attempts = 0,
+			next_attempt_at = CURRENT_TIMESTAMP(6)
+		WHERE
+			id = ?
+		AND
+			subscription_id = ?
+		AND
+			status = 'failed'`,
+		id, subscriptionId,
+	)
+	if err != nil {
+		return fmt.Errorf("replay webhook delivery %d: %w", id, err)
+	}
+
+	return affectedOne(res, id)
+}
+
+const deliveryColumns = `
+			id,
+			subscription_id,
+			event_id,
+			event,
+			payload,
+			status,
+			attempts,
+			next_attempt_at,
+			last_status_code,
+			last_error,
+			created_at,
+			delivered_at`
+
+func deliveryWhereOf(subscriptionId uint, q query.Query) (string, []any) {
+	where, args := deliverySchema.Where(q)
+	if where == "" {
+		return "subscription_id = ?", []any{subscriptionId}
+	}
+	return "subscription_id = ? AND " + where, append([]any{subscriptionId}, args...)
+}
+
+func affectedOne(res sql.Result, id uint) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected check for %d: %w", id, err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
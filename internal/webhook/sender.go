@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"Go-lab/internal/utils/httpconst"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-http-utils/headers"
+)
+
+const userAgent = "Go-lab-Webhook/1.0"
+
+// Message is what goes out in one POST
+type Message struct {
+	Id    string
+	Event string
+	Body  []byte
+}
+
+// Sender signs and POSTs messages. Retrying is up to the caller, it knows how often it already tried.
+type Sender struct {
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewSender(client *http.Client) *Sender {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Sender{
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// Send returns the status code of the receiver, 0 if there was no response. Anything but a 2xx is an error.
+func (s *Sender) Send(ctx context.Context, url, secret string, m Message) (int, error) {
+	timestamp := s.now()
+
+	signature, err := Sign(secret, m.Id, timestamp, m.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(m.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set(headers.ContentType, httpconst.ApplicationJSON)
+	req.Header.Set(headers.UserAgent, userAgent)
+	req.Header.Set(HeaderId, m.Id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(HeaderSignature, signature)
+	req.Header.Set(HeaderEvent, m.Event)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// keep the connection reusable, nobody reads what the receiver says
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
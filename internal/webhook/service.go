@@ -0,0 +1,453 @@
+package webhook
+
+import (
+	"Go-lab/config"
+	"Go-lab/internal/middleware/etag"
+	"Go-lab/internal/utils/dbutils"
+	"Go-lab/internal/utils/paging"
+	"Go-lab/internal/utils/query"
+	"Go-lab/internal/utils/resilience"
+	"Go-lab/internal/utils/validate"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	batchSize   = 50 // deliveries claimed per round
+	concurrency = 4  // POSTs in flight per round
+	maxDelay    = time.Hour
+)
+
+type Service struct {
+	db     *dbutils.DbUtils
+	sender *Sender
+	cfg    config.WebhookConfig
+}
+
+func NewService(dbUtils *dbutils.DbUtils, cfg config.WebhookConfig) *Service {
+	if err := validate.Get().Var(dbUtils, "required"); err != nil {
+		panic(err)
+	}
+
+	// our own retries are persisted, the transport only adds the breaker and the bulkhead
+	opts := resilience.DefaultOptions()
+	opts.MaxAttempts = 1
+	opts.AttemptTimeout = cfg.Timeout
+
+	client := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: resilience.NewTransport("webhook", nil, opts),
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // a receiver that moved has to be updated, not followed
+		},
+	}
+
+	return &Service{
+		db:     dbUtils,
+		sender: NewSender(client),
+		cfg:    cfg,
+	}
+}
+
+// Create stores the subscription with a fresh secret, the returned subscription is the only place it shows up
+func (s *Service) Create(ctx context.Context, subscription *Subscription) (*Subscription, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if err := validate.Get().Var(subscription, "required"); err != nil {
+		return nil, err
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	subscription.Secret = secret
+
+	var created *Subscription
+
+	err = s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		id, err := repo.Create(ctx, subscription)
+		if err != nil {
+			return err
+		}
+
+		created, err = repo.FindById(ctx, *id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func (s *Service) FindAll(ctx context.Context) ([]Subscription, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var subscriptions []Subscription
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		subscriptions, err = repo.FindAll(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+func (s *Service) FindById(ctx context.Context, id uint) (*Subscription, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var subscription *Subscription
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		subscription, err = repo.FindById(ctx, id)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+// Update replaces url, events, description and active, given the row is still at one of the versions the client has seen
+func (s *Service) Update(ctx context.Context, subscription *Subscription, version etag.Versions) (*Subscription, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+	if err := validate.Get().Var(subscription, "required"); err != nil {
+		return nil, err
+	}
+
+	var updated *Subscription
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		current, err := repo.FindById(ctx, *subscription.Id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+		subscription.Secret = current.Secret
+
+		if err = repo.Update(ctx, subscription, version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrConflict
+			}
+			return err
+		}
+
+		updated, err = repo.FindById(ctx, *subscription.Id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (s *Service) Delete(ctx context.Context, id uint, version etag.Versions) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+
+	return s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.Delete(ctx, id, version); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrConflict
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// envelope is the body of every delivery
+type envelope struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+// Publish queues the event for every subscription that wants it, the delivery service sends it.
+// Publishing the same event id again is a no-op.
+func (s *Service) Publish(ctx context.Context, eventId, event string, at time.Time, data any) error {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return err
+	}
+	if err := validate.Get().Var(eventId, "required,notblank,max=40"); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(envelope{Type: event, Timestamp: at.UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		_, err = repo.Enqueue(ctx, eventId, event, payload)
+		return err
+	})
+}
+
+// Deliver sends one batch of due deliveries and returns how many it tried
+func (s *Service) Deliver(ctx context.Context) (int, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return 0, err
+	}
+
+	// long enough for the whole batch to get its turn
+	lease := s.cfg.Timeout * time.Duration(batchSize/concurrency+1)
+
+	var due []dueDelivery
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		due, err = repo.ClaimDue(ctx, batchSize, lease)
+		return err
+	})
+	if err != nil || len(due) == 0 {
+		return 0, err
+	}
+
+	errs := make([]error, len(due))
+	slots := make(chan struct{}, concurrency)
+
+	for i := range due {
+		slots <- struct{}{}
+		go func() {
+			defer func() { <-slots }()
+			errs[i] = s.attempt(ctx, &due[i])
+		}()
+	}
+	for range concurrency {
+		slots <- struct{}{}
+	}
+
+	return len(due), errors.Join(errs...)
+}
+
+func (s *Service) attempt(ctx context.Context, d *dueDelivery) error {
+	start := time.Now()
+	code, sendErr := s.sender.Send(ctx, d.Url, d.Secret, Message{Id: d.EventId, Event: d.Event, Body: d.Payload})
+	if ctx.Err() != nil {
+		return ctx.Err() // shutting down, the lease runs out and it is sent again
+	}
+
+	attempt := &Attempt{
+		DeliveryId:  *d.Id,
+		AttemptedAt: start,
+		DurationMs:  uint(time.Since(start).Milliseconds()),
+	}
+	delivery := d.Delivery
+	delivery.Attempts++
+	delivery.LastStatusCode, delivery.LastError = nil, nil
+	if code != 0 {
+		attempt.StatusCode, delivery.LastStatusCode = &code, &code
+	}
+
+	now := time.Now()
+	switch {
+	case sendErr == nil:
+		delivery.Status = Delivered
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= s.cfg.MaxAttempts:
+		delivery.Status = Failed
+	default:
+		next := now.Add(retryDelay(s.cfg.RetryBase, delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+	if sendErr != nil {
+		msg := truncate(sendErr.Error(), 1000)
+		attempt.Error, delivery.LastError = &msg, &msg
+	}
+
+	return s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		return repo.RecordAttempt(ctx, attempt, &delivery)
+	})
+}
+
+func (s *Service) FindDeliveries(ctx context.Context, subscriptionId uint, p paging.Paging, q query.Query) (*paging.Page[Delivery], error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var page paging.Page[Delivery]
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if _, err = repo.FindById(ctx, subscriptionId); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		deliveries, err := repo.FindDeliveries(ctx, subscriptionId, p, q)
+		if err != nil {
+			return err
+		}
+		page = paging.NewPage(deliveries, p, func(d Delivery) paging.Cursor {
+			return deliverySchema.Cursor(q, d)
+		})
+
+		if p.Total {
+			total, err := repo.CountDeliveries(ctx, subscriptionId, q)
+			if err != nil {
+				return err
+			}
+			page.Total = &total
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, nil
+}
+
+// FindDelivery returns a delivery with all its attempts
+func (s *Service) FindDelivery(ctx context.Context, subscriptionId, id uint) (*Delivery, []Attempt, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, nil, err
+	}
+
+	var delivery *Delivery
+	var attempts []Attempt
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		delivery, err = repo.FindDelivery(ctx, subscriptionId, id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDeliveryNotFound
+			}
+			return err
+		}
+
+		attempts, err = repo.FindAttempts(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return delivery, attempts, nil
+}
+
+// Replay queues a failed delivery again, with the same event id and payload
+func (s *Service) Replay(ctx context.Context, subscriptionId, id uint) (*Delivery, error) {
+	if err := validate.Get().Var(ctx, "required"); err != nil {
+		return nil, err
+	}
+
+	var delivery *Delivery
+
+	err := s.db.WithTransaction(ctx, func(tx *sqlx.Tx) error {
+		repo, err := NewRepo(tx)
+		if err != nil {
+			return err
+		}
+
+		if err = repo.Replay(ctx, subscriptionId, id); err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			if _, err = repo.FindDelivery(ctx, subscriptionId, id); errors.Is(err, sql.ErrNoRows) {
+				return ErrDeliveryNotFound
+			}
+			return ErrNotReplayable
+		}
+
+		delivery, err = repo.FindDelivery(ctx, subscriptionId, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+// retryDelay doubles the base for every failed attempt, up to an hour
+func retryDelay(base time.Duration, attempts uint) time.Duration {
+	d := base
+	for i := uint(1); i < attempts && d < maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxDelay)
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
@@ -0,0 +1,30 @@
+package webhook
+
+import (
+	"Go-lab/internal/outbox"
+	"context"
+	"strconv"
+)
+
+// Sink queues the outbox messages as webhook deliveries. The event id is derived from the message id,
+// so a batch the relay hands over twice is queued once.
+type Sink struct {
+	service *Service
+}
+
+func NewSink(service *Service) *Sink {
+	return &Sink{service: service}
+}
+
+func (s *Sink) Name() string {
+	return "webhook"
+}
+
+func (s *Sink) Send(ctx context.Context, messages []outbox.Message) error {
+	for _, m := range messages {
+		if err := s.service.Publish(ctx, "msg_"+strconv.FormatUint(m.Id, 10), m.Type, m.CreatedAt, m.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
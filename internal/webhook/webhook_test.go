@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	req := require.New(t)
+
+	secret, err := NewSecret()
+	req.NoError(err)
+	req.Regexp(`^whsec_`, secret)
+
+	now := time.Now()
+	body := []byte(`{"type":"player.created"}`)
+	sig, err := Sign(secret, "msg_1", now, body)
+	req.NoError(err)
+
+	h := http.Header{}
+	h.Set(HeaderId, "msg_1")
+	h.Set(HeaderTimestamp, "0")
+	h.Set(HeaderSignature, sig)
+	req.ErrorIs(Verify(secret, h, body, 5*time.Minute), ErrSignature, "stale timestamp")
+
+	h.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.NoError(Verify(secret, h, body, 5*time.Minute))
+	req.ErrorIs(Verify(secret, h, []byte(`{"type":"player.deleted"}`), 5*time.Minute), ErrSignature)
+
+	other, _ := NewSecret()
+	req.ErrorIs(Verify(other, h, body, 5*time.Minute), ErrSignature)
+
+	// during a rotation the receiver may get more than one signature
+	h.Set(HeaderSignature, "v1,bm9wZQ== "+sig)
+	req.NoError(Verify(secret, h, body, 5*time.Minute))
+}
+
+func TestSender(t *testing.T) {
+	req := require.New(t)
+
+	secret, _ := NewSecret()
+
+	var got http.Header
+	var gotBody []byte
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	sender := NewSender(receiver.Client())
+	m := Message{Id: "msg_42", Event: "player.checked_in", Body: []byte(`{"type":"player.checked_in","data":{}}`)}
+
+	code, err := sender.Send(context.Background(), receiver.URL, secret, m)
+	req.NoError(err)
+	req.Equal(http.StatusNoContent, code)
+	req.Equal(m.Body, gotBody)
+	req.Equal("msg_42", got.Get(HeaderId))
+	req.Equal("player.checked_in", got.Get(HeaderEvent))
+	req.NoError(Verify(secret, got, gotBody, time.Minute))
+
+	status = http.StatusServiceUnavailable
+	code, err = sender.Send(context.Background(), receiver.URL, secret, m)
+	req.Error(err)
+	req.Equal(http.StatusServiceUnavailable, code)
+}
+
+func TestRetryDelay(t *testing.T) {
+	req := require.New(t)
+
+	req.Equal(30*time.Second, retryDelay(30*time.Second, 1))
+	req.Equal(60*time.Second, retryDelay(30*time.Second, 2))
+	req.Equal(4*time.Minute, retryDelay(30*time.Second, 4))
+	req.Equal(time.Hour, retryDelay(30*time.Second, 20))
+}
+
+func TestEvents(t *testing.T) {
+	req := require.New(t)
+
+	var e Events
+	req.NoError(e.Scan([]byte("player.created,player.deleted")))
+	req.Equal(Events{"player.created", "player.deleted"}, e)
+
+	v, err := e.Value()
+	req.NoError(err)
+	req.Equal("player.created,player.deleted", v)
+
+	req.NoError(e.Scan(""))
+	req.Nil(e)
+}
+
+func TestToEntity(t *testing.T) {
+	req := require.New(t)
+
+	s, err := ToEntity(SubscriptionDto{Url: "https://partner.example/hook", Events: []string{"player.created"}})
+	req.NoError(err)
+	req.True(s.Active)
+
+	_, err = ToEntity(SubscriptionDto{Url: "ftp://partner.example", Events: []string{"player.created"}})
+	req.Error(err)
+
+	_, err = ToEntity(SubscriptionDto{Url: "https://partner.example/hook", Events: []string{"player.renamed"}})
+	req.Error(err)
+
+	_, err = ToEntity(SubscriptionDto{Url: "https://partner.example/hook"})
+	req.Error(err)
+}
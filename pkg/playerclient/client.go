@@ -0,0 +1,187 @@
+// Package playerclient is the Go client of the player API.
+//
+//	client, _ := playerclient.New(restyClient) // base URL and auth already set on the resty client
+//	p, err := client.Get(ctx, 42)
+//	if errors.Is(err, playerclient.ErrNotFound) { ... }
+//	err = client.Update(ctx, p.Id, p.ETag, playerclient.UpdatePlayer{Name: "Jono"})
+//	if errors.Is(err, playerclient.ErrConflict) { /* reload and retry */ }
+package playerclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-resty/resty/v2"
+)
+
+const (
+	headerETag    = "ETag"
+	headerIfMatch = "If-Match"
+
+	contentTypeMergePatch = "application/merge-patch+json"
+)
+
+type Client struct {
+	rest *resty.Client
+}
+
+// New wraps a resty client whose base URL points at the API root, e.g. http://localhost:8282/lab
+func New(rest *resty.Client) (*Client, error) {
+	if rest == nil {
+		return nil, errors.New("playerclient: a resty client is required")
+	}
+	return &Client{rest: rest}, nil
+}
+
+// List reads one page of players
+func (c *Client) List(ctx context.Context, opts ListOptions) (*Page[Player], error) {
+	var page Page[Player]
+	if _, err := c.do(ctx, http.MethodGet, "player", "", nil, &page, opts.values()); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// All walks every page of the listing, stopping at the first error fn returns
+func (c *Client) All(ctx context.Context, opts ListOptions, fn func(Player) error) error {
+	for {
+		page, err := c.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for _, p := range page.Items {
+			if err = fn(p); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == nil {
+			return nil
+		}
+		opts.Cursor = *page.NextCursor
+		opts.Total = false
+	}
+}
+
+// Trash reads one page of soft deleted players
+func (c *Client) Trash(ctx context.Context, opts ListOptions) (*Page[Player], error) {
+	var page Page[Player]
+	if _, err := c.do(ctx, http.MethodGet, "player/trash", "", nil, &page, opts.values()); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (c *Client) Get(ctx context.Context, id uint) (*Player, error) {
+	return c.player(ctx, http.MethodGet, playerPath(id), "", nil)
+}
+
+func (c *Client) GetByResourceId(ctx context.Context, resourceId string) (*Player, error) {
+	return c.player(ctx, http.MethodGet, "player/resource/"+url.PathEscape(resourceId), "", nil)
+}
+
+// Create returns the id of the new player
+func (c *Client) Create(ctx context.Context, p CreatePlayer) (uint, error) {
+	var id uint
+	if _, err := c.do(ctx, http.MethodPost, "player", "", p, &id, nil); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Update replaces the editable fields. The server answers without a body, Get the player for its new ETag.
+func (c *Client) Update(ctx context.Context, id uint, etag string, p UpdatePlayer) error {
+	_, err := c.do(ctx, http.MethodPut, playerPath(id), etag, p, nil, nil)
+	return err
+}
+
+// Patch applies a JSON Merge Patch, e.g. map[string]any{"description": nil} clears the description
+func (c *Client) Patch(ctx context.Context, id uint, etag string, patch map[string]any) (*Player, error) {
+	return c.player(ctx, http.MethodPatch, playerPath(id), etag, patch)
+}
+
+// Checkin records a visit, checkin may be nil
+func (c *Client) Checkin(ctx context.Context, id uint, etag string, checkin *Checkin) (*Player, error) {
+	var body any
+	if checkin != nil {
+		body = checkin
+	}
+	return c.player(ctx, http.MethodPut, "player/checkin/"+strconv.FormatUint(uint64(id), 10), etag, body)
+}
+
+// Checkins reads one page of the check-in history of a player, newest first by default
+func (c *Client) Checkins(ctx context.Context, id uint, opts ListOptions) (*Page[CheckinEntry], error) {
+	var page Page[CheckinEntry]
+	if _, err := c.do(ctx, http.MethodGet, playerPath(id)+"/checkins", "", nil, &page, opts.values()); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Delete moves the player to the trash
+func (c *Client) Delete(ctx context.Context, id uint, etag string) error {
+	_, err := c.do(ctx, http.MethodDelete, playerPath(id), etag, nil, nil, nil)
+	return err
+}
+
+// Restore takes the player out of the trash, etag is the one of the deleted player
+func (c *Client) Restore(ctx context.Context, id uint, etag string) (*Player, error) {
+	return c.player(ctx, http.MethodPost, playerPath(id)+"/restore", etag, nil)
+}
+
+func (c *Client) player(ctx context.Context, method, path, etag string, body any) (*Player, error) {
+	var p Player
+	resp, err := c.do(ctx, method, path, etag, body, &p, nil)
+	if err != nil {
+		return nil, err
+	}
+	p.ETag = resp.Header().Get(headerETag)
+	return &p, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path, etag string, body, result any, query url.Values) (*resty.Response, error) {
+	if ctx == nil {
+		return nil, errors.New("playerclient: a context is required")
+	}
+
+	req := c.rest.R().
+		SetContext(ctx).
+		SetError(&Problem{})
+
+	if result != nil {
+		req.SetResult(result)
+	}
+	if query != nil {
+		req.SetQueryParamsFromValues(query)
+	}
+	if etag != "" {
+		req.SetHeader(headerIfMatch, etag)
+	}
+	if body != nil {
+		req.SetBody(body)
+		if method == http.MethodPatch {
+			req.SetHeader("Content-Type", contentTypeMergePatch)
+		}
+	}
+
+	resp, err := req.Execute(method, path)
+	if err != nil {
+		return nil, fmt.Errorf("playerclient: %s %s: %w", method, path, err)
+	}
+	if resp.IsError() {
+		e := &Error{Method: method, URL: resp.Request.URL, Status: resp.StatusCode()}
+		if p, ok := resp.Error().(*Problem); ok && p.Status != 0 {
+			e.Problem = p
+		}
+		return nil, e
+	}
+
+	return resp, nil
+}
+
+func playerPath(id uint) string {
+	return "player/" + strconv.FormatUint(uint64(id), 10)
+}
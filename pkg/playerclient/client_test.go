@@ -0,0 +1,87 @@
+package playerclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	req := require.New(t)
+
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Method+" "+r.URL.Path+" "+r.Header.Get(headerIfMatch))
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/lab/player/1":
+			w.Header().Set(headerETag, `W/"10"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1,"resource_id":"r1","name":"Jono"}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/lab/player/checkin/1":
+			body, _ := io.ReadAll(r.Body)
+			req.JSONEq(`{"source":"kiosk"}`, string(body))
+			w.Header().Set(headerETag, `W/"11"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1,"resource_id":"r1","name":"Jono"}`))
+		case r.Method == http.MethodPatch:
+			req.Equal(contentTypeMergePatch, r.Header.Get("Content-Type"))
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"type":"urn:golab:problem:conflict","title":"Conflict","status":409,"detail":"player already modified"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/lab/player":
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("cursor") == "" {
+				json.NewEncoder(w).Encode(Page[Player]{Items: []Player{{Id: 1}, {Id: 2}}, NextCursor: ptr("next")})
+				return
+			}
+			json.NewEncoder(w).Encode(Page[Player]{Items: []Player{{Id: 3}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := New(resty.New().SetBaseURL(server.URL + "/lab"))
+	req.NoError(err)
+	ctx := context.Background()
+
+	p, err := client.Get(ctx, 1)
+	req.NoError(err)
+	req.Equal("Jono", p.Name)
+	req.Equal(`W/"10"`, p.ETag)
+
+	p, err = client.Checkin(ctx, p.Id, p.ETag, &Checkin{Source: ptr("kiosk")})
+	req.NoError(err)
+	req.Equal(`W/"11"`, p.ETag)
+
+	_, err = client.Patch(ctx, 1, p.ETag, map[string]any{"name": "x"})
+	req.True(errors.Is(err, ErrConflict))
+	var e *Error
+	req.True(errors.As(err, &e))
+	req.Equal("player already modified", e.Problem.Detail)
+
+	_, err = client.Get(ctx, 2)
+	req.True(errors.Is(err, ErrNotFound))
+	req.False(errors.Is(err, ErrConflict))
+
+	var ids []uint
+	req.NoError(client.All(ctx, ListOptions{Limit: 2}, func(p Player) error {
+		ids = append(ids, p.Id)
+		return nil
+	}))
+	req.Equal([]uint{1, 2, 3}, ids)
+
+	req.Contains(seen, `PUT /lab/player/checkin/1 W/"10"`)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
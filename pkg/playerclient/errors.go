@@ -0,0 +1,63 @@
+package playerclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Match these with errors.Is, the concrete error is always an *Error
+var (
+	ErrNotFound     = errors.New("player not found")
+	ErrConflict     = errors.New("player was modified by another request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrInvalid      = errors.New("invalid request")
+)
+
+// Problem is the RFC 7807 body the server answers errors with
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	RequestId string `json:"request_id"`
+	Errors    []struct {
+		Field   string `json:"field"`
+		Rule    string `json:"rule"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Error is a non 2xx answer from the server
+type Error struct {
+	Method  string
+	URL     string
+	Status  int
+	Problem *Problem // nil when the body was not a problem document
+}
+
+func (e *Error) Error() string {
+	if e.Problem != nil && e.Problem.Detail != "" {
+		return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.Status, e.Problem.Detail)
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.Status, http.StatusText(e.Status))
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict || e.Status == http.StatusPreconditionFailed
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.Status == http.StatusForbidden
+	case ErrInvalid:
+		return e.Status == http.StatusBadRequest || e.Status == http.StatusUnprocessableEntity ||
+			e.Status == http.StatusPreconditionRequired
+	}
+	return false
+}
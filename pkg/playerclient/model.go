@@ -0,0 +1,91 @@
+package playerclient
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Player struct {
+	Id          uint       `json:"id"`
+	ResourceId  string     `json:"resource_id"`
+	Name        string     `json:"name"`
+	Description *string    `json:"description"`
+	LastCheckin *time.Time `json:"last_checkin"`
+	CreatedAt   *time.Time `json:"created_at"`
+	CreatedBy   *uint      `json:"created_by"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+	UpdatedBy   *uint      `json:"updated_by"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+
+	// ETag is the version of the player, pass it back on Update, Patch, Checkin, Delete and Restore
+	ETag string `json:"-"`
+}
+
+type CreatePlayer struct {
+	ResourceId  string  `json:"resource_id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+}
+
+type UpdatePlayer struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+}
+
+type Checkin struct {
+	Source *string `json:"source,omitempty"`
+	Note   *string `json:"note,omitempty"`
+}
+
+type CheckinEntry struct {
+	Id          uint       `json:"id"`
+	PlayerId    uint       `json:"player_id"`
+	CheckedInAt *time.Time `json:"checked_in_at"`
+	CheckedInBy *uint      `json:"checked_in_by"`
+	Source      *string    `json:"source"`
+	Note        *string    `json:"note"`
+}
+
+// Page is one page of a listing. Follow NextCursor with ListOptions.Cursor to get the next one.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	Total      *uint   `json:"total,omitempty"`
+}
+
+// ListOptions pages, filters and sorts a listing. Filters take the server's field[op] keys,
+// e.g. Filters: url.Values{"name[prefix]": {"jo"}}.
+type ListOptions struct {
+	Limit  uint
+	Cursor string // "" is the first page
+	Total  bool
+
+	Sort           string // e.g. "-created_at,name"
+	Filters        url.Values
+	IncludeDeleted bool
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	for key, values := range o.Filters {
+		v[key] = append([]string(nil), values...)
+	}
+
+	// always keyset, the offset mode is only kept for old clients
+	v.Set("cursor", o.Cursor)
+	if o.Limit > 0 {
+		v.Set("limit", strconv.FormatUint(uint64(o.Limit), 10))
+	}
+	if o.Total {
+		v.Set("total", "true")
+	}
+	if o.Sort != "" {
+		v.Set("sort", o.Sort)
+	}
+	if o.IncludeDeleted {
+		v.Set("include_deleted", "true")
+	}
+	return v
+}